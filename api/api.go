@@ -33,6 +33,14 @@ func NewHTTPServer(cfg *config.Config, db *gorm.DB, log *zap.Logger) HTTPServer
 
 	e.Validator = validation.NewValidator(validate)
 
+	// Only trust forwarded headers behind a proxy, otherwise clients could
+	// pick their own IP and dodge the sign in lockout
+	if cfg.HTTP.TrustProxy {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	} else {
+		e.IPExtractor = echo.ExtractIPDirect()
+	}
+
 	return HTTPServer{
 		db:         db,
 		cfg:        cfg,
@@ -65,8 +73,14 @@ func (s *HTTPServer) RunHTTPServer() {
 
 	api.Settings()
 	api.Accounts()
+	api.OIDC()
 	api.Heartbeat()
+	api.WellKnown()
 	api.Tags()
+	api.Reports()
+	api.Audit()
+	api.Trash()
+	api.Assets()
 
 	openPort, err := s.testPort()
 	if err != nil {
@@ -1,11 +1,44 @@
 package middlewares
 
 import (
+	"errors"
+	"maribooru/internal/account"
+	"maribooru/internal/helpers"
+	"net/http"
+
 	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
+// JWTMiddleware validates the token and then turns away banned users, so a
+// ban takes effect on tokens issued before it
 func (m *Middleware) JWTMiddleware() echo.MiddlewareFunc {
 	m.log.Debug("JWTMiddleware:Authenticating")
-	return echojwt.WithConfig(m.cfg.JWT.Config)
+	jwtMiddleware := echojwt.WithConfig(m.cfg.JWT.Config)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return jwtMiddleware(m.banMiddleware(next))
+	}
+}
+
+func (m *Middleware) banMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := helpers.GetUserID(c, m.cfg.JWT.Keys)
+		if err != nil {
+			m.log.Error("Failed to get user from token", zap.Error(err))
+			return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+		}
+
+		ban, err := account.NewBanModel(m.db).GetActive(userID)
+		if err == nil {
+			return helpers.Response(c, http.StatusForbidden, ban.ToPublicResponse(), account.BanMessage(ban))
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			m.log.Error("Failed to check bans", zap.Error(err))
+			return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to check bans")
+		}
+
+		return next(c)
+	}
 }
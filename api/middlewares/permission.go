@@ -10,24 +10,25 @@ import (
 	"go.uber.org/zap"
 )
 
-func (m *Middleware) PermissionMiddleware(requiredPermission permission.Level) echo.MiddlewareFunc {
+// PermissionMiddleware lets the request through when the user's roles grant
+// any of the required capabilities. A Level can be passed as the requirement,
+// it stands for the capabilities of its bits.
+func (m *Middleware) PermissionMiddleware(required permission.Requirement) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			m.log.Debug("PermissionMiddleware:Authenticating")
-			userID, err := helpers.GetUserID(c, m.cfg.JWT.Secret)
+			userID, err := helpers.GetUserID(c, m.cfg.JWT.Keys)
 			if err != nil {
 				m.log.Error("Failed to get user from token", zap.Error(err))
 				return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
 			}
 
-			mod := permission.NewModel(m.db)
-			userPermission, err := mod.GetByUserID(userID)
+			allowed, err := account.HasCapability(m.db, userID, required)
 			if err != nil {
-				m.log.Error("Failed to get user permission", zap.Error(err))
+				m.log.Error("Failed to get user capabilities", zap.Error(err))
 				return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
 			}
-
-			if userPermission.Permission&requiredPermission == 0 {
+			if !allowed {
 				return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
 			}
 
@@ -37,25 +38,5 @@ func (m *Middleware) PermissionMiddleware(requiredPermission permission.Level) e
 }
 
 func (m *Middleware) AdminMiddleware() echo.MiddlewareFunc {
-	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			m.log.Debug("AdminMiddleware:Authenticating")
-			userID, err := helpers.GetUserID(c, m.cfg.JWT.Secret)
-			if err != nil {
-				m.log.Error("Failed to get user from token", zap.Error(err))
-				return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
-			}
-
-			mod := account.NewAdminModel(m.db)
-			isAdmin, err := mod.IsAdmin(userID)
-			if err != nil {
-				return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
-			}
-			if !isAdmin {
-				return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
-			}
-
-			return next(c)
-		}
-	}
+	return m.PermissionMiddleware(permission.CapAdmin)
 }
@@ -27,17 +27,22 @@ func TestPermission(t *testing.T) {
 	cfg := &config.Config{
 		JWT: config.JWT{
 			Secret: "secret",
+			Keys:   helpers.NewHMACKeys("secret"),
 		},
 	}
 
 	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
-	db.AutoMigrate(account.User{}, permission.Permission{})
+	db.AutoMigrate(account.User{}, permission.Permission{}, permission.Role{}, permission.RoleCapability{}, permission.UserRole{})
 
 	log, err := zap.NewDevelopment()
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	if err := permission.NewRoleModel(db).SeedBuiltIn(); err != nil {
+		t.Fatal(err)
+	}
+
 	mw := NewMiddleware(cfg, db, log)
 
 	pass, err := helpers.PasswordHash("unittest")
@@ -96,6 +101,20 @@ func TestPermission(t *testing.T) {
 		},
 	}
 
+	// Access comes from the roles mirroring the bits, and the built in roles
+	// are cumulative so e.g. approving implies reading and writing
+	allowed := map[string]permission.Level{
+		"moderator":    permission.Moderate | permission.Approve | permission.Read | permission.Write,
+		"approver":     permission.Approve | permission.Read | permission.Write,
+		"approve-only": permission.Approve | permission.Read | permission.Write,
+		"rw":           permission.Read | permission.Write,
+		"wo":           permission.Read | permission.Write,
+		"ro":           permission.Read,
+	}
+	for _, user := range users {
+		user.Roles = permission.NewUserRoles(user.Permission.Permission.Roles()...)
+	}
+
 	if err := db.Create(users).Error; err != nil {
 		t.Fatal(err)
 	}
@@ -103,7 +122,7 @@ func TestPermission(t *testing.T) {
 	tests := []permission.Level{permission.Moderate, permission.Approve, permission.Read, permission.Write}
 
 	for _, user := range users {
-		jwt, err := helpers.GenerateJWT(user.ID, user.Name, cfg.JWT.Secret, time.Minute*2)
+		jwt, err := helpers.GenerateJWT(user.ID, user.Name, cfg.JWT.Keys, time.Minute*2)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -121,8 +140,8 @@ func TestPermission(t *testing.T) {
 			}))
 			h(c)
 
-			log.Debug("Checking permissions", zap.Any("user", user.Permission.Permission), zap.Any("against", test), zap.Any("bitwise", test&user.Permission.Permission))
-			if test&user.Permission.Permission != 0 {
+			log.Debug("Checking permissions", zap.Any("user", user.Name), zap.Any("against", test), zap.Any("allowed", allowed[user.Name]))
+			if test&allowed[user.Name] != 0 {
 				log.Debug("Allowed")
 				assert.Equal(t, http.StatusOK, rec.Code)
 			} else {
@@ -140,17 +159,22 @@ func TestAdmin(t *testing.T) {
 	cfg := &config.Config{
 		JWT: config.JWT{
 			Secret: "secret",
+			Keys:   helpers.NewHMACKeys("secret"),
 		},
 	}
 
 	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
-	db.AutoMigrate(account.User{}, permission.Permission{}, account.Admin{})
+	db.AutoMigrate(account.User{}, permission.Permission{}, account.Admin{}, permission.Role{}, permission.RoleCapability{}, permission.UserRole{})
 
 	log, err := zap.NewDevelopment()
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	if err := permission.NewRoleModel(db).SeedBuiltIn(); err != nil {
+		t.Fatal(err)
+	}
+
 	mw := NewMiddleware(cfg, db, log)
 
 	pass, err := helpers.PasswordHash("unittest")
@@ -184,8 +208,16 @@ func TestAdmin(t *testing.T) {
 	if err := db.Create(&admin).Error; err != nil {
 		t.Fatal(err)
 	}
+	if err := permission.NewRoleModel(db).AssignRoles(users[0].ID, permission.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	// An admin row left behind without the role grants nothing
+	if err := db.Create(&account.Admin{UserID: users[1].ID}).Error; err != nil {
+		t.Fatal(err)
+	}
 
-	jwt, err := helpers.GenerateJWT(users[0].ID, users[0].Name, cfg.JWT.Secret, time.Minute*2)
+	jwt, err := helpers.GenerateJWT(users[0].ID, users[0].Name, cfg.JWT.Keys, time.Minute*2)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -204,7 +236,7 @@ func TestAdmin(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, rec.Code)
 
-	jwt, err = helpers.GenerateJWT(users[1].ID, users[1].Name, cfg.JWT.Secret, time.Minute*2)
+	jwt, err = helpers.GenerateJWT(users[1].ID, users[1].Name, cfg.JWT.Keys, time.Minute*2)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -218,3 +250,110 @@ func TestAdmin(t *testing.T) {
 	h(c)
 	assert.Equal(t, http.StatusUnauthorized, rec.Code)
 }
+
+func TestRoleCapabilities(t *testing.T) {
+	log.Println("Start test role capabilities")
+	e := echo.New()
+
+	cfg := &config.Config{
+		JWT: config.JWT{
+			Secret: "secret",
+			Keys:   helpers.NewHMACKeys("secret"),
+		},
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	db.AutoMigrate(account.User{}, permission.Permission{}, account.Admin{}, permission.Role{}, permission.RoleCapability{}, permission.UserRole{})
+
+	log, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := permission.NewRoleModel(db).SeedBuiltIn(); err != nil {
+		t.Fatal(err)
+	}
+
+	mw := NewMiddleware(cfg, db, log)
+
+	pass, err := helpers.PasswordHash("unittest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := []*account.User{
+		{Name: "role-contributor", Password: pass, Roles: permission.NewUserRoles(permission.RoleContributor)},
+		{Name: "role-janitor", Password: pass, Roles: permission.NewUserRoles(permission.RoleJanitor)},
+		{Name: "role-admin", Password: pass, Roles: permission.NewUserRoles(permission.RoleAdmin)},
+		{Name: "role-none", Password: pass},
+	}
+
+	if err := db.Create(users).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user     *account.User
+		required permission.Requirement
+		want     int
+	}{
+		{users[0], permission.CapWrite, http.StatusOK},
+		{users[0], permission.Write, http.StatusOK},
+		{users[0], permission.CapTagDelete, http.StatusUnauthorized},
+		{users[1], permission.CapTagDelete, http.StatusOK},
+		{users[1], permission.Approve, http.StatusOK},
+		{users[1], permission.CapModerate, http.StatusUnauthorized},
+		{users[2], permission.CapModerate, http.StatusOK},
+		{users[2], permission.CapAdmin, http.StatusOK},
+		{users[3], permission.CapRead, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		jwt, err := helpers.GenerateJWT(test.user.ID, test.user.Name, cfg.JWT.Keys, time.Minute*2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(echo.GET, "/", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", jwt))
+
+		h := mw.PermissionMiddleware(test.required)(echo.HandlerFunc(func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		}))
+		h(c)
+
+		if rec.Code != test.want {
+			t.Errorf("%s against %v = %d, want %d", test.user.Name, test.required, rec.Code, test.want)
+		}
+	}
+
+	// Taking a role away takes its access away and rewrites the mirrored bits
+	roles := permission.NewRoleModel(db)
+	if err := roles.SetUserRoles(users[2].ID, []string{permission.RoleMember}); err != nil {
+		t.Fatal(err)
+	}
+	allowed, err := account.HasCapability(db, users[2].ID, permission.CapAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, false, allowed)
+
+	userPermission, err := permission.NewModel(db).GetByUserID(users[2].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, permission.Read, userPermission.Permission)
+
+	// So does taking a capability away from a role
+	if _, err := roles.Update(permission.Role{Name: permission.RoleJanitor, Capabilities: []permission.RoleCapability{{RoleName: permission.RoleJanitor, Capability: permission.CapRead}}}); err != nil {
+		t.Fatal(err)
+	}
+	allowed, err = account.HasCapability(db, users[1].ID, permission.CapTagDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, false, allowed)
+}
@@ -9,6 +9,9 @@ func (av *VersionOne) Accounts() {
 	userHandler := account.NewUserHandler(av.db, av.cfg, av.log)
 	adminHandler := account.NewAdminHandler(av.db, av.cfg, av.log)
 	permissionHandler := permission.NewHandler(av.db, av.cfg, av.log)
+	inviteHandler := account.NewInviteHandler(av.db, av.cfg, av.log)
+	roleHandler := permission.NewRoleHandler(av.db, av.cfg, av.log)
+	banHandler := account.NewBanHandler(av.db, av.cfg, av.log)
 
 	user := av.api.Group("/user")
 	user.POST("/sign-in", userHandler.SignIn)
@@ -19,6 +22,19 @@ func (av *VersionOne) Accounts() {
 	user.PUT("", userHandler.SelfUpdate, av.mw.JWTMiddleware())
 	user.DELETE("", userHandler.SelfDelete, av.mw.JWTMiddleware())
 
+	invites := av.api.Group("/invites", av.mw.JWTMiddleware(), av.mw.PermissionMiddleware(permission.CapInvite))
+	invites.POST("", inviteHandler.Create)
+	invites.GET("", inviteHandler.GetOwn)
+	invites.DELETE("/:id", inviteHandler.RevokeOwn)
+
+	bans := av.api.Group("/bans")
+	bans.GET("", banHandler.GetPublic)
+
+	moderationBan := av.api.Group("/moderation/bans", av.mw.JWTMiddleware(), av.mw.PermissionMiddleware(permission.CapModerate))
+	moderationBan.POST("", banHandler.Create)
+	moderationBan.GET("", banHandler.GetAll)
+	moderationBan.DELETE("/:id", banHandler.Lift)
+
 	users := av.api.Group("/users")
 	users.GET("/:id", userHandler.GetUserByID)
 	users.GET("", userHandler.GetAllUsers)
@@ -31,7 +47,28 @@ func (av *VersionOne) Accounts() {
 	adminManage.DELETE("/:id", adminHandler.RemoveAdmin)
 
 	adminUser := admin.Group("/user")
+	adminUser.GET("/pending", adminHandler.GetPendingUsers)
+	adminUser.PUT("/:id/approve", adminHandler.ApproveUser)
+	adminUser.DELETE("/:id/reject", adminHandler.RejectUser)
 	adminUser.PUT("/:id", adminHandler.AdministrativeUserUpdate)
 	adminUser.GET("/permission/:id", permissionHandler.GetByUserID)
 	adminUser.PUT("/permission", permissionHandler.Set)
+	adminUser.GET("/:id/roles", roleHandler.GetUserRoles)
+	adminUser.PUT("/:id/roles", roleHandler.SetUserRoles)
+
+	adminRole := admin.Group("/roles")
+	adminRole.GET("", roleHandler.GetAll)
+	adminRole.GET("/capabilities", roleHandler.GetCapabilities)
+	adminRole.POST("", roleHandler.Create)
+	adminRole.PUT("", roleHandler.Update)
+	adminRole.DELETE("/:name", roleHandler.Delete)
+
+	adminInvite := admin.Group("/invites")
+	adminInvite.GET("", inviteHandler.GetAll)
+	adminInvite.DELETE("/:id", inviteHandler.Revoke)
+
+	adminLockout := admin.Group("/lockouts")
+	adminLockout.GET("", adminHandler.GetLockouts)
+	adminLockout.DELETE("/user/:id", adminHandler.UnlockUser)
+	adminLockout.DELETE("/ip/:ip", adminHandler.UnlockIP)
 }
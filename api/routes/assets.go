@@ -0,0 +1,15 @@
+package routes
+
+import "maribooru/internal/asset"
+
+func (av *VersionOne) Assets() {
+	if av.cfg.AssetStorage.UseS3 {
+		av.log.Warn("Serving assets from S3 is not supported yet, /data answers 501")
+	}
+
+	handler := asset.NewHandler(av.cfg, av.log)
+
+	data := av.e.Group("/data")
+	data.GET("/*", handler.Serve)
+	data.HEAD("/*", handler.Serve)
+}
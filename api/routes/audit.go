@@ -0,0 +1,10 @@
+package routes
+
+import "maribooru/internal/audit"
+
+func (av *VersionOne) Audit() {
+	handler := audit.NewHandler(av.db, av.cfg, av.log)
+
+	adminAudit := av.api.Group("/admin/audit", av.mw.JWTMiddleware(), av.mw.AdminMiddleware())
+	adminAudit.GET("", handler.GetAll)
+}
@@ -0,0 +1,17 @@
+package routes
+
+import "maribooru/internal/oidc"
+
+func (av *VersionOne) OIDC() {
+	if !av.cfg.OIDC.Enabled {
+		return
+	}
+
+	handler := oidc.NewHandler(av.db, av.cfg, av.log)
+
+	user := av.api.Group("/user")
+	user.GET("/oidc/login", handler.Login)
+	user.GET("/oidc/callback", handler.Callback)
+	user.GET("/identities", handler.GetIdentities, av.mw.JWTMiddleware())
+	user.DELETE("/identities/:id", handler.Unlink, av.mw.JWTMiddleware())
+}
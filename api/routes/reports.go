@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"maribooru/internal/notification"
+	"maribooru/internal/permission"
+	"maribooru/internal/report"
+)
+
+func (av *VersionOne) Reports() {
+	reportHandler := report.NewHandler(av.db, av.cfg, av.log)
+
+	reports := av.api.Group("/reports", av.mw.JWTMiddleware())
+	reports.POST("", reportHandler.Create)
+	reports.GET("", reportHandler.GetOwn)
+
+	queue := av.api.Group("/moderation/reports", av.mw.JWTMiddleware(), av.mw.PermissionMiddleware(permission.CapModerate))
+	queue.GET("", reportHandler.GetQueue)
+	queue.PUT("/bulk", reportHandler.Bulk)
+	queue.PUT("/:id/claim", reportHandler.Claim)
+	queue.PUT("/:id/resolve", reportHandler.Resolve)
+	queue.PUT("/:id/dismiss", reportHandler.Dismiss)
+
+	notificationHandler := notification.NewHandler(av.db, av.cfg, av.log)
+
+	notifications := av.api.Group("/notifications", av.mw.JWTMiddleware())
+	notifications.GET("", notificationHandler.GetAll)
+	notifications.PUT("/read", notificationHandler.MarkAllRead)
+	notifications.PUT("/:id/read", notificationHandler.MarkRead)
+}
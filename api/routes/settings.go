@@ -8,4 +8,7 @@ func (av *VersionOne) Settings() {
 	handler := setting.NewHandler(av.db, av.cfg, av.log)
 	settings := av.api.Group("/settings")
 	settings.GET("", handler.Get)
+
+	adminSettings := av.api.Group("/admin/settings", av.mw.JWTMiddleware(), av.mw.AdminMiddleware())
+	adminSettings.PUT("", handler.Update)
 }
@@ -1,11 +1,14 @@
 package routes
 
-import "maribooru/internal/tag"
+import (
+	"maribooru/internal/permission"
+	"maribooru/internal/tag"
+)
 
 func (av *VersionOne) Tags() {
 	categoryHandler := tag.NewCategoryHandler(av.db, av.cfg, av.log)
 
-	category := av.api.Group("/tag-categories", av.mw.JWTMiddleware(), av.mw.AdminMiddleware())
+	category := av.api.Group("/tag-categories", av.mw.JWTMiddleware(), av.mw.PermissionMiddleware(permission.CapTagCategory))
 	category.POST("", categoryHandler.CreateCategory)
 	category.PUT("", categoryHandler.UpdateCategory)
 	category.DELETE("/:id", categoryHandler.DeleteCategory)
@@ -14,12 +17,23 @@ func (av *VersionOne) Tags() {
 	publicCategory.GET("", categoryHandler.GetCategories)
 	publicCategory.GET("/:id", categoryHandler.GetCategoryByID)
 
+	artistHandler := tag.NewArtistHandler(av.db, av.cfg, av.log)
+
+	artist := av.api.Group("/artists", av.mw.JWTMiddleware())
+	artist.PUT("", artistHandler.Save, av.mw.PermissionMiddleware(permission.CapWrite))
+	artist.DELETE("/:id", artistHandler.Delete, av.mw.PermissionMiddleware(permission.CapTagDelete))
+
+	publicArtist := av.api.Group("/artists")
+	publicArtist.GET("", artistHandler.GetAll)
+	publicArtist.GET("/lookup", artistHandler.Lookup)
+	publicArtist.GET("/:id", artistHandler.GetByID)
+
 	tagHandler := tag.NewTagHandler(av.db, av.cfg, av.log)
 
 	tag := av.api.Group("/tags", av.mw.JWTMiddleware())
-	tag.POST("", tagHandler.Create)
-	tag.PUT("", tagHandler.Update)
-	tag.DELETE("/:id", tagHandler.Delete)
+	tag.POST("", tagHandler.Create, av.mw.PermissionMiddleware(permission.CapWrite))
+	tag.PUT("", tagHandler.Update, av.mw.PermissionMiddleware(permission.CapWrite))
+	tag.DELETE("/:id", tagHandler.Delete, av.mw.PermissionMiddleware(permission.CapTagDelete))
 
 	publicTag := av.api.Group("/tags")
 	publicTag.GET("", tagHandler.GetAll)
@@ -0,0 +1,12 @@
+package routes
+
+import "maribooru/internal/trash"
+
+func (av *VersionOne) Trash() {
+	handler := trash.NewHandler(av.db, av.cfg, av.log)
+
+	adminTrash := av.api.Group("/admin/trash", av.mw.JWTMiddleware(), av.mw.AdminMiddleware())
+	adminTrash.GET("/:type", handler.GetAll)
+	adminTrash.PUT("/:type/:id/restore", handler.Restore)
+	adminTrash.DELETE("/:type/:id", handler.Purge)
+}
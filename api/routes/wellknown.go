@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (av *VersionOne) WellKnown() {
+	wellKnown := av.e.Group("/.well-known")
+	wellKnown.GET("/jwks.json", av.JWKS)
+}
+
+// JWKS publishes the token verification keys in the standard format rather
+// than the API envelope, so other services can consume it as is
+func (av *VersionOne) JWKS(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, av.cfg.JWT.Keys.JWKS())
+}
@@ -6,6 +6,7 @@ import (
 	"maribooru/internal/config"
 	"maribooru/internal/db"
 	"maribooru/internal/helpers"
+	"maribooru/internal/trash"
 
 	"go.uber.org/zap"
 )
@@ -24,6 +25,8 @@ func main() {
 		log.Fatal("Failed to connect to database", zap.Error(err))
 	}
 
+	go trash.RunPurgeJob(db, cfg.Trash, log)
+
 	e := api.NewHTTPServer(cfg, db, log)
 	e.RunHTTPServer()
 }
@@ -2,9 +2,12 @@ package account
 
 import (
 	"errors"
+	"maribooru/internal/audit"
 	"maribooru/internal/config"
 	"maribooru/internal/helpers"
+	"maribooru/internal/permission"
 	"maribooru/internal/setting"
+	"net"
 	"net/http"
 	"time"
 
@@ -16,10 +19,15 @@ import (
 
 type (
 	AdminResponse struct {
-		AdminID   uuid.UUID `json:"admin_id"`
 		UserID    uuid.UUID `json:"user_id"`
 		CreatedAt time.Time `json:"created_at"`
-		UpdatedAt time.Time `json:"updated_at"`
+	}
+
+	LockoutResponse struct {
+		Key           string    `json:"key"`
+		Failures      int       `json:"failures"`
+		LockedUntil   time.Time `json:"locked_until"`
+		LastFailureAt time.Time `json:"last_failure_at"`
 	}
 
 	AdminHandler struct {
@@ -31,13 +39,28 @@ type (
 	}
 )
 
-func (a *Admin) ToResponse() AdminResponse {
+func adminResponse(role permission.UserRole) AdminResponse {
 	return AdminResponse{
-		AdminID:   a.ID,
-		UserID:    a.UserID,
-		CreatedAt: a.CreatedAt,
-		UpdatedAt: a.UpdatedAt,
+		UserID:    role.UserID,
+		CreatedAt: role.CreatedAt,
+	}
+}
+
+func (l *LoginAttempt) ToResponse() LockoutResponse {
+	return LockoutResponse{
+		Key:           l.Key,
+		Failures:      l.Failures,
+		LockedUntil:   l.LockedUntil,
+		LastFailureAt: l.LastFailureAt,
+	}
+}
+
+func (l LoginAttemptSlice) ToResponse() []LockoutResponse {
+	response := make([]LockoutResponse, 0)
+	for _, attempt := range l {
+		response = append(response, attempt.ToResponse())
 	}
+	return response
 }
 
 func NewAdminHandler(db *gorm.DB, cfg *config.Config, log *zap.Logger) *AdminHandler {
@@ -106,7 +129,7 @@ func (a *AdminHandler) CreateAdmin(c echo.Context) error {
 		return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
 	}
 
-	hashedPassword, err := helpers.PasswordHash(request.Password)
+	hashedPassword, err := a.cfg.Password.Hasher().Hash(request.Password)
 	if err != nil {
 		a.log.Error("Error while hashing password", zap.Error(err))
 		return helpers.Response(c, http.StatusInternalServerError, nil, "There was an error while hashing password")
@@ -115,7 +138,6 @@ func (a *AdminHandler) CreateAdmin(c echo.Context) error {
 
 	tx := a.db.Begin()
 	userModel := NewUserModel(tx)
-	adminModel := NewAdminModel(tx)
 
 	data, err := userModel.Create(request.ToTable())
 	if err != nil {
@@ -127,8 +149,7 @@ func (a *AdminHandler) CreateAdmin(c echo.Context) error {
 		return helpers.Response(c, http.StatusInternalServerError, nil, "There was an error while creating admin")
 	}
 
-	admin, err := adminModel.AssignAdmin(data.ID)
-	if err != nil {
+	if err := permission.NewRoleModel(tx).AssignRoles(data.ID, permission.RoleAdmin); err != nil {
 		tx.Rollback()
 		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to assign admin")
 	}
@@ -146,16 +167,33 @@ func (a *AdminHandler) CreateAdmin(c echo.Context) error {
 		}
 	}
 
-	token, err := helpers.GenerateJWT(data.ID, data.Name, a.cfg.JWT.Secret, a.cfg.AppConfig.TokenLifetime)
+	token, err := helpers.GenerateJWT(data.ID, data.Name, a.cfg.JWT.Keys, a.cfg.AppConfig.TokenLifetime)
 	if err != nil {
 		tx.Rollback()
 		a.log.Error("Error while generating token", zap.Error(err))
 		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to generate token")
 	}
 
-	data.Admin = admin
-
 	tx.Commit()
+
+	// Reload so the response carries the admin role
+	if reloaded, err := a.userModel.GetByID(data.ID); err == nil {
+		data = reloaded
+	}
+
+	// The initial admin creates itself, without a token
+	actorID, err := helpers.GetUserID(c, a.cfg.JWT.Keys)
+	if err != nil {
+		actorID = data.ID
+	}
+	audit.Record(a.db, a.log, c, audit.Log{
+		ActorID:    actorID,
+		Action:     audit.ActionAdminCreated,
+		TargetType: audit.TargetUser,
+		TargetID:   data.ID.String(),
+		After:      audit.ToJSON(data.ToResponse(true)),
+	})
+
 	return helpers.Response(c, http.StatusOK, data.ToResponse(true), token)
 }
 
@@ -188,6 +226,11 @@ func (a *AdminHandler) GetAllAdmin(c echo.Context) error {
 
 func (a *AdminHandler) AssignAdmin(c echo.Context) error {
 	a.log.Debug("UserHandler: AssignAdmin")
+	actorID, err := helpers.GetUserID(c, a.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
 	id, err := uuid.Parse(c.Param("id"))
 	if err != nil {
 		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
@@ -202,25 +245,51 @@ func (a *AdminHandler) AssignAdmin(c echo.Context) error {
 		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to assign admin")
 	}
 
-	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
+	audit.Record(a.db, a.log, c, audit.Log{
+		ActorID:    actorID,
+		Action:     audit.ActionAdminAssigned,
+		TargetType: audit.TargetUser,
+		TargetID:   id.String(),
+		After:      audit.ToJSON(adminResponse(data)),
+	})
+
+	return helpers.Response(c, http.StatusOK, adminResponse(data), "")
 }
 
 func (a *AdminHandler) RemoveAdmin(c echo.Context) error {
 	a.log.Debug("UserHandler: RemoveAdmin")
+	actorID, err := helpers.GetUserID(c, a.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
 	id, err := uuid.Parse(c.Param("id"))
 	if err != nil {
 		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
 	}
 
-	data, err := a.model.RemoveAdmin(id)
-	if err != nil {
+	if err := a.model.RemoveAdmin(id); err != nil {
 		a.log.Error("Failed to remove admin", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to remove admin")
 	}
 
-	return helpers.Response(c, http.StatusOK, data, "")
+	audit.Record(a.db, a.log, c, audit.Log{
+		ActorID:    actorID,
+		Action:     audit.ActionAdminRemoved,
+		TargetType: audit.TargetUser,
+		TargetID:   id.String(),
+	})
+
+	return helpers.Response(c, http.StatusOK, nil, "")
 }
 
 func (a *AdminHandler) AdministrativeUserUpdate(c echo.Context) error {
+	a.log.Debug("AdminHandler: AdministrativeUserUpdate")
+	actorID, err := helpers.GetUserID(c, a.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
 	id, err := uuid.Parse(c.Param("id"))
 	if err != nil {
 		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
@@ -235,10 +304,199 @@ func (a *AdminHandler) AdministrativeUserUpdate(c echo.Context) error {
 		return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
 	}
 
+	before, err := a.userModel.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "User not found")
+		}
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to update user")
+	}
+
 	user, err := a.userModel.Update(request.ToTable(id))
 	if err != nil {
 		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to update user")
 	}
 
+	audit.Record(a.db, a.log, c, audit.Log{
+		ActorID:    actorID,
+		Action:     audit.ActionUserUpdated,
+		TargetType: audit.TargetUser,
+		TargetID:   id.String(),
+		Before:     audit.ToJSON(before.ToResponse(true)),
+		After:      audit.ToJSON(user.ToResponse(true)),
+	})
+
 	return helpers.Response(c, http.StatusOK, user, "")
 }
+
+func (a *AdminHandler) GetLockouts(c echo.Context) error {
+	a.log.Debug("AdminHandler: GetLockouts")
+
+	params := helpers.GenericPagedQuery{
+		Limit:    50,
+		Offset:   0,
+		Keywords: "",
+	}
+	if err := c.Bind(&params); err != nil {
+		a.log.Error("Failed to set limit and offset, defaulting to 50 limit and 0 offset", zap.Error(err))
+	}
+
+	data, total, err := NewLoginAttemptModel(a.db).GetAllLocked(params)
+	if err != nil {
+		a.log.Error("Failed to get lockouts", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get lockouts")
+	}
+
+	paged := helpers.PageData(data.ToResponse(), int(total), params.Offset, params.Limit)
+
+	return helpers.Response(c, http.StatusOK, paged, "")
+}
+
+func (a *AdminHandler) UnlockUser(c echo.Context) error {
+	a.log.Debug("AdminHandler: UnlockUser")
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
+	}
+
+	return a.unlock(c, "user:"+id.String(), audit.TargetUser, id.String())
+}
+
+func (a *AdminHandler) UnlockIP(c echo.Context) error {
+	a.log.Debug("AdminHandler: UnlockIP")
+	ip := net.ParseIP(c.Param("ip"))
+	if ip == nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "A valid IP address is needed")
+	}
+
+	return a.unlock(c, "ip:"+ip.String(), audit.TargetIP, ip.String())
+}
+
+func (a *AdminHandler) unlock(c echo.Context, key, targetType, targetID string) error {
+	adminID, err := helpers.GetUserID(c, a.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	if err := NewLoginAttemptModel(a.db).Reset(key); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "No failed sign ins recorded")
+		}
+		a.log.Error("Failed to unlock", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to unlock")
+	}
+
+	audit.Record(a.db, a.log, c, audit.Log{
+		ActorID:    adminID,
+		Action:     audit.ActionSignInUnlocked,
+		TargetType: targetType,
+		TargetID:   targetID,
+	})
+
+	return helpers.Response(c, http.StatusOK, nil, "")
+}
+
+func (a *AdminHandler) GetPendingUsers(c echo.Context) error {
+	a.log.Debug("AdminHandler: GetPendingUsers")
+
+	params := UserParams{
+		GenericPagedQuery: helpers.GenericPagedQuery{
+			Limit:    50,
+			Offset:   0,
+			Keywords: "",
+			Sort:     "created_at asc",
+		},
+		Pending: true,
+	}
+	if err := c.Bind(&params); err != nil {
+		a.log.Error("Failed to set limit and offset, defaulting to 50 limit and 0 offset", zap.Error(err))
+	}
+	params.Pending = true
+
+	data, total, err := a.userModel.GetAll(params)
+	if err != nil {
+		a.log.Error("Failed to get pending users", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get pending users")
+	}
+
+	paged := helpers.PageData(data.ToResponse(true), int(total), params.Offset, params.Limit)
+
+	return helpers.Response(c, http.StatusOK, paged, "")
+}
+
+func (a *AdminHandler) ApproveUser(c echo.Context) error {
+	a.log.Debug("AdminHandler: ApproveUser")
+	actorID, err := helpers.GetUserID(c, a.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
+	}
+
+	if err := a.userModel.SetPendingApproval(id, false); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "User not found")
+		}
+		a.log.Error("Failed to approve user", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to approve user")
+	}
+
+	data, err := a.userModel.GetByID(id)
+	if err != nil {
+		a.log.Error("Failed to get user", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get user")
+	}
+
+	audit.Record(a.db, a.log, c, audit.Log{
+		ActorID:    actorID,
+		Action:     audit.ActionUserApproved,
+		TargetType: audit.TargetUser,
+		TargetID:   id.String(),
+	})
+
+	return helpers.Response(c, http.StatusOK, data.ToResponse(true), "")
+}
+
+func (a *AdminHandler) RejectUser(c echo.Context) error {
+	a.log.Debug("AdminHandler: RejectUser")
+	actorID, err := helpers.GetUserID(c, a.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
+	}
+
+	data, err := a.userModel.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "User not found")
+		}
+		a.log.Error("Failed to get user", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get user")
+	}
+
+	if !data.PendingApproval {
+		return helpers.Response(c, http.StatusConflict, nil, "User is not awaiting approval")
+	}
+
+	if err := a.userModel.Delete(id); err != nil {
+		a.log.Error("Failed to reject user", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to reject user")
+	}
+
+	audit.Record(a.db, a.log, c, audit.Log{
+		ActorID:    actorID,
+		Action:     audit.ActionUserRejected,
+		TargetType: audit.TargetUser,
+		TargetID:   id.String(),
+		Before:     audit.ToJSON(data.ToResponse(true)),
+	})
+
+	return helpers.Response(c, http.StatusOK, nil, "")
+}
@@ -0,0 +1,94 @@
+package account
+
+import (
+	"maribooru/internal/audit"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"maribooru/internal/permission"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gopkg.in/go-playground/assert.v1"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestAdminFollowsRole(t *testing.T) {
+	e := echo.New()
+
+	cfg := &config.Config{
+		JWT: config.JWT{Secret: "secret", Keys: helpers.NewHMACKeys("secret")},
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(User{}, Admin{}, permission.Permission{}, permission.Role{}, permission.RoleCapability{}, permission.UserRole{}, audit.Log{})
+	if err := permission.NewRoleModel(db).SeedBuiltIn(); err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actor, _ := NewUserModel(db).Create(User{Name: "admin-actor", Password: "-", Roles: permission.NewUserRoles(permission.RoleAdmin)})
+	target, _ := NewUserModel(db).Create(User{Name: "admin-target", Password: "-"})
+
+	// An admin row from before roles doesn't make the target an admin
+	if err := db.Create(&Admin{UserID: target.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := helpers.GenerateJWT(actor.ID, actor.Name, cfg.JWT.Keys, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewAdminHandler(db, cfg, log)
+	call := func(h echo.HandlerFunc) int {
+		req := httptest.NewRequest(echo.PUT, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(target.ID.String())
+		if err := h(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code
+	}
+	isAdmin := func() bool {
+		allowed, err := HasCapability(db, target.ID, permission.CapAdmin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return allowed
+	}
+
+	assert.Equal(t, http.StatusOK, call(handler.AssignAdmin))
+	assert.Equal(t, true, isAdmin())
+	assert.Equal(t, http.StatusConflict, call(handler.AssignAdmin))
+
+	// Taking the role away through the roles endpoint is enough to assign
+	// the admin position again
+	if err := permission.NewRoleModel(db).SetUserRoles(target.ID, []string{permission.RoleMember}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, false, isAdmin())
+	assert.Equal(t, http.StatusOK, call(handler.AssignAdmin))
+	assert.Equal(t, true, isAdmin())
+
+	assert.Equal(t, http.StatusOK, call(handler.RemoveAdmin))
+	assert.Equal(t, false, isAdmin())
+
+	count := int64(0)
+	db.Model(&Admin{}).Where("user_id = ?", target.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
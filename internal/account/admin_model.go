@@ -1,14 +1,16 @@
 package account
 
 import (
+	"maribooru/internal/permission"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
 type (
+	// Admin is the admin position from before roles. Rows are only read by
+	// the role migration, being an admin means holding the admin role.
 	Admin struct {
 		ID        uuid.UUID `gorm:"primary_key;type:uuid"`
 		UserID    uuid.UUID `gorm:"type:uuid;unique"`
@@ -33,27 +35,37 @@ func NewAdminModel(db *gorm.DB) *AdminModel {
 	}
 }
 
-func (a *AdminModel) AssignAdmin(uuid uuid.UUID) (Admin, error) {
-	admin := Admin{
-		UserID: uuid,
+// AssignAdmin gives the user the admin role, returning gorm.ErrDuplicatedKey
+// when they already have it
+func (a *AdminModel) AssignAdmin(userID uuid.UUID) (permission.UserRole, error) {
+	role := permission.UserRole{
+		UserID:   userID,
+		RoleName: permission.RoleAdmin,
 	}
-	err := a.db.Model(&Admin{}).Create(&admin).Clauses(clause.Returning{}).Error
-	return admin, err
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		count := int64(0)
+		err := tx.Model(&permission.UserRole{}).
+			Where("user_id = ? AND role_name = ?", userID, permission.RoleAdmin).
+			Count(&count).
+			Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return gorm.ErrDuplicatedKey
+		}
+		return tx.Create(&role).Error
+	})
+	return role, err
 }
 
-func (p *AdminModel) IsAdmin(id uuid.UUID) (bool, error) {
-	admin := Admin{}
-	err := p.db.Model(&Admin{}).Where("user_id = ?", id).First(&admin).Error
-	if err != nil {
-		return false, err
-	}
-	return admin.ID != uuid.Nil, nil
-}
-
-func (a *AdminModel) RemoveAdmin(uuid uuid.UUID) (Admin, error) {
-	admin := Admin{
-		UserID: uuid,
-	}
-	err := a.db.Model(&Admin{}).Where("user_id = ?", uuid).Delete(&admin).Error
-	return admin, err
+// RemoveAdmin takes the admin role away, along with any admin row left from
+// before roles
+func (a *AdminModel) RemoveAdmin(userID uuid.UUID) error {
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&Admin{}).Error; err != nil {
+			return err
+		}
+		return permission.NewRoleModel(tx).RemoveRoles(userID, permission.RoleAdmin)
+	})
 }
@@ -0,0 +1,311 @@
+package account
+
+import (
+	"errors"
+	"maribooru/internal/audit"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"maribooru/internal/permission"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type (
+	BanCreate struct {
+		UserID    uuid.UUID `json:"user_id" validate:"required"`
+		Reason    string    `json:"reason" validate:"required,max=1000"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	BanParams struct {
+		helpers.GenericPagedQuery
+		ActiveOnly bool      `query:"active"`
+		UserID     uuid.UUID `query:"user_id"`
+	}
+
+	BanResponse struct {
+		ID         uuid.UUID    `json:"id"`
+		User       UserResponse `json:"user"`
+		Moderator  UserResponse `json:"moderator"`
+		Reason     string       `json:"reason"`
+		Permanent  bool         `json:"permanent"`
+		ExpiresAt  time.Time    `json:"expires_at"`
+		LiftedAt   time.Time    `json:"lifted_at"`
+		LiftedByID uuid.UUID    `json:"lifted_by_id"`
+		CreatedAt  time.Time    `json:"created_at"`
+	}
+
+	PublicBanResponse struct {
+		UserID    uuid.UUID `json:"user_id"`
+		Name      string    `json:"name"`
+		Reason    string    `json:"reason"`
+		Permanent bool      `json:"permanent"`
+		ExpiresAt time.Time `json:"expires_at"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	BanHandler struct {
+		db    *gorm.DB
+		model *BanModel
+		cfg   *config.Config
+		log   *zap.Logger
+	}
+)
+
+func (b *BanCreate) ToTable() Ban {
+	return Ban{
+		UserID:    b.UserID,
+		Reason:    b.Reason,
+		ExpiresAt: b.ExpiresAt,
+	}
+}
+
+func (b *Ban) ToResponse() BanResponse {
+	return BanResponse{
+		ID:         b.ID,
+		User:       b.User.ToResponse(false),
+		Moderator:  b.Moderator.ToResponse(false),
+		Reason:     b.Reason,
+		Permanent:  b.Permanent(),
+		ExpiresAt:  b.ExpiresAt,
+		LiftedAt:   b.LiftedAt,
+		LiftedByID: b.LiftedByID,
+		CreatedAt:  b.CreatedAt,
+	}
+}
+
+func (b *Ban) ToPublicResponse() PublicBanResponse {
+	return PublicBanResponse{
+		UserID:    b.UserID,
+		Name:      b.User.Name,
+		Reason:    b.Reason,
+		Permanent: b.Permanent(),
+		ExpiresAt: b.ExpiresAt,
+		CreatedAt: b.CreatedAt,
+	}
+}
+
+func (b BanSlice) ToResponse() []BanResponse {
+	response := make([]BanResponse, 0)
+	for _, ban := range b {
+		response = append(response, ban.ToResponse())
+	}
+	return response
+}
+
+func (b BanSlice) ToPublicResponse() []PublicBanResponse {
+	response := make([]PublicBanResponse, 0)
+	for _, ban := range b {
+		response = append(response, ban.ToPublicResponse())
+	}
+	return response
+}
+
+func NewBanHandler(db *gorm.DB, cfg *config.Config, log *zap.Logger) *BanHandler {
+	return &BanHandler{
+		db:    db,
+		model: NewBanModel(db),
+		cfg:   cfg,
+		log:   log,
+	}
+}
+
+func (b *BanHandler) Create(c echo.Context) error {
+	b.log.Debug("BanHandler: Create")
+	moderatorID, err := helpers.GetUserID(c, b.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	var request BanCreate
+	if err := c.Bind(&request); err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "Invalid request")
+	}
+	if err := c.Validate(&request); err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
+	}
+
+	if !request.ExpiresAt.IsZero() && request.ExpiresAt.Before(time.Now()) {
+		return helpers.Response(c, http.StatusBadRequest, nil, "Expiry must be in the future")
+	}
+	if request.UserID == moderatorID {
+		return helpers.Response(c, http.StatusBadRequest, nil, "You can't ban yourself")
+	}
+
+	if _, err := NewUserModel(b.db).GetByID(request.UserID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "User not found")
+		}
+		b.log.Error("Failed to get user", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to ban user")
+	}
+
+	// Moderators can't ban each other, only an admin can
+	forbidden, err := b.outranks(request.UserID, moderatorID)
+	if err != nil {
+		b.log.Error("Failed to check capabilities", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to ban user")
+	}
+	if forbidden {
+		return helpers.Response(c, http.StatusForbidden, nil, "You can't ban this user")
+	}
+
+	ban := request.ToTable()
+	ban.ModeratorID = moderatorID
+	created, err := b.model.CreateActive(ban)
+	if err != nil {
+		if errors.Is(err, ErrAlreadyBanned) {
+			return helpers.Response(c, http.StatusConflict, nil, err.Error())
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "User not found")
+		}
+		b.log.Error("Failed to ban user", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to ban user")
+	}
+
+	action := audit.ActionUserBanned
+	if !created.Permanent() {
+		action = audit.ActionUserSuspended
+	}
+	b.audit(c, moderatorID, action, created.UserID, map[string]interface{}{
+		"ban_id":     created.ID,
+		"reason":     created.Reason,
+		"expires_at": created.ExpiresAt,
+	})
+
+	data, err := b.model.GetByID(created.ID)
+	if err != nil {
+		b.log.Error("Failed to get ban", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get ban")
+	}
+
+	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
+}
+
+func (b *BanHandler) GetAll(c echo.Context) error {
+	b.log.Debug("BanHandler: GetAll")
+	params := BanParams{
+		GenericPagedQuery: helpers.GenericPagedQuery{
+			Limit:  50,
+			Offset: 0,
+		},
+	}
+	if err := c.Bind(&params); err != nil {
+		b.log.Error("Failed to set limit and offset, defaulting to 50 limit and 0 offset", zap.Error(err))
+	}
+
+	data, total, err := b.model.GetAll(params)
+	if err != nil {
+		b.log.Error("Failed to get bans", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get bans")
+	}
+
+	paged := helpers.PageData(data.ToResponse(), int(total), params.Offset, params.Limit)
+
+	return helpers.Response(c, http.StatusOK, paged, "")
+}
+
+// GetPublic lists the bans in effect, without who issued them
+func (b *BanHandler) GetPublic(c echo.Context) error {
+	b.log.Debug("BanHandler: GetPublic")
+	params := BanParams{
+		GenericPagedQuery: helpers.GenericPagedQuery{
+			Limit:  50,
+			Offset: 0,
+		},
+	}
+	if err := c.Bind(&params); err != nil {
+		b.log.Error("Failed to set limit and offset, defaulting to 50 limit and 0 offset", zap.Error(err))
+	}
+	params.ActiveOnly = true
+
+	data, total, err := b.model.GetAll(params)
+	if err != nil {
+		b.log.Error("Failed to get bans", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get bans")
+	}
+
+	paged := helpers.PageData(data.ToPublicResponse(), int(total), params.Offset, params.Limit)
+
+	return helpers.Response(c, http.StatusOK, paged, "")
+}
+
+func (b *BanHandler) Lift(c echo.Context) error {
+	b.log.Debug("BanHandler: Lift")
+	moderatorID, err := helpers.GetUserID(c, b.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
+	}
+
+	ban, err := b.model.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Ban not found")
+		}
+		b.log.Error("Failed to get ban", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to lift ban")
+	}
+
+	if err := b.model.Lift(id, moderatorID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusConflict, nil, "Ban is no longer in effect")
+		}
+		b.log.Error("Failed to lift ban", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to lift ban")
+	}
+
+	b.audit(c, moderatorID, audit.ActionUserUnbanned, ban.UserID, map[string]interface{}{
+		"ban_id": ban.ID,
+	})
+
+	return helpers.Response(c, http.StatusOK, nil, "")
+}
+
+// outranks reports whether the target can't be banned by the moderator,
+// which is the case for fellow moderators unless the moderator is an admin
+func (b *BanHandler) outranks(targetID, moderatorID uuid.UUID) (bool, error) {
+	targetIsModerator, err := HasCapability(b.db, targetID, permission.CapModerate)
+	if err != nil || !targetIsModerator {
+		return false, err
+	}
+
+	moderatorIsAdmin, err := HasCapability(b.db, moderatorID, permission.CapAdmin)
+	if err != nil {
+		return false, err
+	}
+	if !moderatorIsAdmin {
+		return true, nil
+	}
+
+	// Admins can't be banned at all
+	return HasCapability(b.db, targetID, permission.CapAdmin)
+}
+
+func (b *BanHandler) audit(c echo.Context, actorID uuid.UUID, action string, userID uuid.UUID, detail map[string]interface{}) {
+	audit.Record(b.db, b.log, c, audit.Log{
+		ActorID:    actorID,
+		Action:     action,
+		TargetType: audit.TargetUser,
+		TargetID:   userID.String(),
+		Detail:     audit.ToJSON(detail),
+	})
+}
+
+// BanMessage describes a ban to the banned user
+func BanMessage(ban Ban) string {
+	if ban.Permanent() {
+		return "Account is banned"
+	}
+	return "Account is suspended until " + ban.ExpiresAt.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,128 @@
+package account
+
+import (
+	"encoding/json"
+	"errors"
+	"maribooru/internal/audit"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"maribooru/internal/permission"
+	"maribooru/internal/validation"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gopkg.in/go-playground/assert.v1"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestBans(t *testing.T) {
+	e := echo.New()
+	e.Validator = validation.NewValidator(validator.New())
+
+	cfg := &config.Config{
+		AppConfig: config.AppConfig{TokenLifetime: time.Minute},
+		JWT:       config.JWT{Secret: "secret", Keys: helpers.NewHMACKeys("secret")},
+		Password:  config.Password{Algorithm: helpers.AlgorithmBcrypt, BcryptCost: 4},
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(User{}, Admin{}, permission.Permission{}, permission.Role{}, permission.RoleCapability{}, permission.UserRole{}, Ban{}, LoginAttempt{}, audit.Log{})
+
+	log, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := permission.NewRoleModel(db).SeedBuiltIn(); err != nil {
+		t.Fatal(err)
+	}
+
+	pass, err := cfg.Password.Hasher().Hash("unittest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	moderate := permission.Permission{Permission: permission.Read | permission.Moderate}
+	moderatorRoles := moderate.Permission.Roles()
+	moderator, _ := NewUserModel(db).Create(User{Name: "ban-moderator", Password: pass, Permission: moderate, Roles: permission.NewUserRoles(moderatorRoles...)})
+	other, _ := NewUserModel(db).Create(User{Name: "ban-other-moderator", Password: pass, Permission: moderate, Roles: permission.NewUserRoles(moderatorRoles...)})
+	target, _ := NewUserModel(db).Create(User{Name: "ban-target", Password: pass})
+
+	token, err := helpers.GenerateJWT(moderator.ID, moderator.Name, cfg.JWT.Keys, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bans := NewBanHandler(db, cfg, log)
+	ban := func(request BanCreate) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(request)
+		req := httptest.NewRequest(echo.POST, "/", strings.NewReader(string(body)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		if err := bans.Create(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	users := NewUserHandler(db, cfg, log)
+	signIn := func(name string) int {
+		body, _ := json.Marshal(SignIn{NameOrEmail: name, Password: "unittest"})
+		req := httptest.NewRequest(echo.POST, "/", strings.NewReader(string(body)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		if err := users.SignIn(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, ban(BanCreate{UserID: other.ID, Reason: "rival"}).Code)
+	assert.Equal(t, http.StatusBadRequest, ban(BanCreate{UserID: target.ID, Reason: "late", ExpiresAt: time.Now().Add(-time.Hour)}).Code)
+
+	rec := ban(BanCreate{UserID: target.ID, Reason: "spam", ExpiresAt: time.Now().Add(time.Hour)})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, http.StatusConflict, ban(BanCreate{UserID: target.ID, Reason: "spam again"}).Code)
+	if _, err := NewBanModel(db).CreateActive(Ban{UserID: target.ID, ModeratorID: other.ID, Reason: "spam too"}); !errors.Is(err, ErrAlreadyBanned) {
+		t.Errorf("CreateActive() error = %v, want %v", err, ErrAlreadyBanned)
+	}
+	assert.Equal(t, http.StatusForbidden, signIn("ban-target"))
+
+	// Rejected sign ins aren't logged as signed in
+	var signIns, rejected int64
+	db.Model(&audit.Log{}).Where("action = ? AND target_id = ?", audit.ActionSignIn, target.ID.String()).Count(&signIns)
+	db.Model(&audit.Log{}).Where("action = ? AND target_id = ?", audit.ActionSignInBanned, target.ID.String()).Count(&rejected)
+	assert.Equal(t, int64(0), signIns)
+	assert.Equal(t, int64(1), rejected)
+
+	active, err := NewBanModel(db).GetActive(target.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "ban-target", active.ToPublicResponse().Name)
+	if err := NewBanModel(db).Lift(active.ID, moderator.ID); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, signIn("ban-target"))
+
+	// Suspensions stop applying once they run out
+	expired := Ban{UserID: target.ID, ModeratorID: moderator.ID, Reason: "old", ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := db.Create(&expired).Error; err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, signIn("ban-target"))
+
+	var entries int64
+	db.Model(&audit.Log{}).Where("action = ? AND target_id = ?", audit.ActionUserSuspended, target.ID.String()).Count(&entries)
+	assert.Equal(t, int64(1), entries)
+}
@@ -0,0 +1,163 @@
+package account
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type (
+	// Ban without an expiry is permanent, one with an expiry is a suspension
+	// that stops applying on its own once the expiry passes
+	Ban struct {
+		ID          uuid.UUID `gorm:"primary_key;type:uuid"`
+		UserID      uuid.UUID `gorm:"type:uuid;not null;index"`
+		User        User      `gorm:"foreignKey:UserID"`
+		ModeratorID uuid.UUID `gorm:"type:uuid;default:null"`
+		Moderator   User      `gorm:"foreignKey:ModeratorID"`
+		Reason      string    `gorm:"not null"`
+		ExpiresAt   time.Time `gorm:"default:null;index"`
+		LiftedAt    time.Time `gorm:"default:null"`
+		LiftedByID  uuid.UUID `gorm:"type:uuid;default:null"`
+		CreatedAt   time.Time
+		UpdatedAt   time.Time
+	}
+
+	BanSlice []Ban
+
+	BanModel struct {
+		db *gorm.DB
+	}
+)
+
+// ErrAlreadyBanned is returned when banning a user that has a ban in effect
+var ErrAlreadyBanned = errors.New("User is already banned")
+
+func (b *Ban) BeforeCreate(tx *gorm.DB) error {
+	b.ID = uuid.New()
+	return nil
+}
+
+func (b *Ban) Permanent() bool {
+	return b.ExpiresAt.IsZero()
+}
+
+func NewBanModel(db *gorm.DB) *BanModel {
+	return &BanModel{
+		db: db,
+	}
+}
+
+func (b *BanModel) active(tx *gorm.DB) *gorm.DB {
+	return tx.
+		Where("bans.lifted_at IS NULL").
+		Where("bans.expires_at IS NULL OR bans.expires_at > ?", time.Now())
+}
+
+func (b *BanModel) Create(ban Ban) (Ban, error) {
+	err := b.db.Create(&ban).Clauses(clause.Returning{}).Error
+	return ban, err
+}
+
+// CreateActive bans the user unless a ban is already in effect. The user row
+// is locked while checking, so two moderators banning at once can't both
+// get through and leave a ban that lifting wouldn't end.
+func (b *BanModel) CreateActive(ban Ban) (Ban, error) {
+	err := b.db.Transaction(func(tx *gorm.DB) error {
+		user := User{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			Where("id = ?", ban.UserID).
+			First(&user).
+			Error
+		if err != nil {
+			return err
+		}
+
+		count := int64(0)
+		if err := b.active(tx.Model(&Ban{})).Where("user_id = ?", ban.UserID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrAlreadyBanned
+		}
+
+		ban, err = NewBanModel(tx).Create(ban)
+		return err
+	})
+	return ban, err
+}
+
+// GetActive returns the ban currently applying to the user, a permanent ban
+// wins over suspensions and otherwise the one ending last
+func (b *BanModel) GetActive(userID uuid.UUID) (Ban, error) {
+	ban := Ban{}
+	err := b.active(b.db.Model(&Ban{})).
+		Preload("User").
+		Where("user_id = ?", userID).
+		Order("expires_at IS NULL DESC").
+		Order("expires_at DESC").
+		First(&ban).
+		Error
+	return ban, err
+}
+
+func (b *BanModel) GetByID(id uuid.UUID) (Ban, error) {
+	ban := Ban{}
+	err := b.db.Model(&Ban{}).
+		Preload("User").
+		Preload("Moderator").
+		Where("id = ?", id).
+		First(&ban).
+		Error
+	return ban, err
+}
+
+func (b *BanModel) GetAll(params BanParams) (BanSlice, int64, error) {
+	bans := BanSlice{}
+	tx := b.db.
+		Model(&Ban{}).
+		Preload("User").
+		Preload("Moderator")
+
+	if params.ActiveOnly {
+		tx = b.active(tx)
+	}
+	if params.UserID != uuid.Nil {
+		tx = tx.Where("user_id = ?", params.UserID)
+	}
+
+	total := int64(0)
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := tx.Order("created_at desc").
+		Limit(params.Limit).
+		Offset(params.Offset).
+		Find(&bans).
+		Error
+
+	return bans, total, err
+}
+
+// Lift ends a ban early, lifting an already lifted or expired ban fails with
+// ErrRecordNotFound
+func (b *BanModel) Lift(id, liftedByID uuid.UUID) error {
+	res := b.active(b.db.Model(&Ban{})).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"lifted_at":    time.Now(),
+			"lifted_by_id": liftedByID,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package account
+
+import (
+	"maribooru/internal/permission"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// HasCapability checks the capabilities of every role assigned to the user.
+// Roles are the only source of access, the permission bits and admin rows
+// are left in place for display and never grant anything on their own
+func HasCapability(db *gorm.DB, userID uuid.UUID, required permission.Requirement) (bool, error) {
+	capabilities, err := permission.NewRoleModel(db).GetCapabilitiesByUserID(userID)
+	if err != nil {
+		return false, err
+	}
+	return capabilities.HasAny(required), nil
+}
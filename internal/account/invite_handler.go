@@ -0,0 +1,204 @@
+package account
+
+import (
+	"errors"
+	"maribooru/internal/audit"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type (
+	InviteCreate struct {
+		MaxUses   int       `json:"max_uses" validate:"min=0"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	InviteParams struct {
+		helpers.GenericPagedQuery
+	}
+
+	InviteResponse struct {
+		ID        uuid.UUID    `json:"id"`
+		Code      string       `json:"code"`
+		MaxUses   int          `json:"max_uses"`
+		Uses      int          `json:"uses"`
+		ExpiresAt time.Time    `json:"expires_at"`
+		CreatedAt time.Time    `json:"created_at"`
+		CreatedBy UserResponse `json:"created_by"`
+	}
+
+	InviteHandler struct {
+		db    *gorm.DB
+		model *InviteModel
+		cfg   *config.Config
+		log   *zap.Logger
+	}
+)
+
+func (i *InviteCreate) ToTable() Invite {
+	return Invite{
+		MaxUses:   i.MaxUses,
+		ExpiresAt: i.ExpiresAt,
+	}
+}
+
+func (i *Invite) ToResponse() InviteResponse {
+	return InviteResponse{
+		ID:        i.ID,
+		Code:      i.Code,
+		MaxUses:   i.MaxUses,
+		Uses:      i.Uses,
+		ExpiresAt: i.ExpiresAt,
+		CreatedAt: i.CreatedAt,
+		CreatedBy: i.CreatedBy.ToResponse(false),
+	}
+}
+
+func (i InviteSlice) ToResponse() []InviteResponse {
+	response := make([]InviteResponse, 0)
+	for _, invite := range i {
+		response = append(response, invite.ToResponse())
+	}
+	return response
+}
+
+func NewInviteHandler(db *gorm.DB, cfg *config.Config, log *zap.Logger) *InviteHandler {
+	return &InviteHandler{
+		db:    db,
+		model: NewInviteModel(db),
+		cfg:   cfg,
+		log:   log,
+	}
+}
+
+func (i *InviteHandler) Create(c echo.Context) error {
+	i.log.Debug("InviteHandler: Create")
+	userID, err := helpers.GetUserID(c, i.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	var request InviteCreate
+	if err := c.Bind(&request); err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "Invalid request")
+	}
+	if err := c.Validate(&request); err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
+	}
+
+	if !request.ExpiresAt.IsZero() && request.ExpiresAt.Before(time.Now()) {
+		return helpers.Response(c, http.StatusBadRequest, nil, "Expiry must be in the future")
+	}
+
+	invite := request.ToTable()
+	invite.CreatedByID = userID
+	invite.Code, err = helpers.RandomString(12)
+	if err != nil {
+		i.log.Error("Failed to generate invite code", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to create invite")
+	}
+
+	data, err := i.model.Create(invite)
+	if err != nil {
+		i.log.Error("Failed to create invite", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to create invite")
+	}
+
+	audit.Record(i.db, i.log, c, audit.Log{
+		ActorID:    userID,
+		Action:     audit.ActionInviteCreated,
+		TargetType: audit.TargetInvite,
+		TargetID:   data.ID.String(),
+		After:      audit.ToJSON(InviteCreate{MaxUses: data.MaxUses, ExpiresAt: data.ExpiresAt}),
+	})
+
+	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
+}
+
+func (i *InviteHandler) GetOwn(c echo.Context) error {
+	i.log.Debug("InviteHandler: GetOwn")
+	userID, err := helpers.GetUserID(c, i.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	return i.getAll(c, userID)
+}
+
+func (i *InviteHandler) GetAll(c echo.Context) error {
+	i.log.Debug("InviteHandler: GetAll")
+	return i.getAll(c, uuid.Nil)
+}
+
+func (i *InviteHandler) getAll(c echo.Context, createdByID uuid.UUID) error {
+	params := InviteParams{
+		GenericPagedQuery: helpers.GenericPagedQuery{
+			Limit:  50,
+			Offset: 0,
+		},
+	}
+	if err := c.Bind(&params); err != nil {
+		i.log.Error("Failed to set limit and offset, defaulting to 50 limit and 0 offset", zap.Error(err))
+	}
+
+	data, total, err := i.model.GetAll(createdByID, params)
+	if err != nil {
+		i.log.Error("Failed to get invites", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get invites")
+	}
+
+	paged := helpers.PageData(data.ToResponse(), int(total), params.Offset, params.Limit)
+
+	return helpers.Response(c, http.StatusOK, paged, "")
+}
+
+func (i *InviteHandler) RevokeOwn(c echo.Context) error {
+	i.log.Debug("InviteHandler: RevokeOwn")
+	userID, err := helpers.GetUserID(c, i.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	return i.revoke(c, userID, userID)
+}
+
+func (i *InviteHandler) Revoke(c echo.Context) error {
+	i.log.Debug("InviteHandler: Revoke")
+	userID, err := helpers.GetUserID(c, i.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	return i.revoke(c, userID, uuid.Nil)
+}
+
+func (i *InviteHandler) revoke(c echo.Context, actorID, createdByID uuid.UUID) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
+	}
+
+	if err := i.model.Revoke(id, createdByID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Invite not found")
+		}
+		i.log.Error("Failed to revoke invite", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to revoke invite")
+	}
+
+	audit.Record(i.db, i.log, c, audit.Log{
+		ActorID:    actorID,
+		Action:     audit.ActionInviteRevoked,
+		TargetType: audit.TargetInvite,
+		TargetID:   id.String(),
+	})
+
+	return helpers.Response(c, http.StatusOK, nil, "")
+}
@@ -0,0 +1,105 @@
+package account
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type (
+	Invite struct {
+		ID          uuid.UUID `gorm:"primary_key;type:uuid"`
+		Code        string    `gorm:"not null;unique"`
+		MaxUses     int       `gorm:"not null;default:0"`
+		Uses        int       `gorm:"not null;default:0"`
+		ExpiresAt   time.Time `gorm:"default:null"`
+		CreatedByID uuid.UUID `gorm:"type:uuid;default:null;index"`
+		CreatedBy   User      `gorm:"foreignKey:CreatedByID"`
+		CreatedAt   time.Time
+		UpdatedAt   time.Time
+		DeletedAt   gorm.DeletedAt
+	}
+
+	InviteSlice []Invite
+
+	InviteModel struct {
+		db *gorm.DB
+	}
+)
+
+func (i *Invite) BeforeCreate(tx *gorm.DB) error {
+	i.ID = uuid.New()
+	return nil
+}
+
+func NewInviteModel(db *gorm.DB) *InviteModel {
+	return &InviteModel{
+		db: db,
+	}
+}
+
+func (i *InviteModel) Create(invite Invite) (Invite, error) {
+	err := i.db.Create(&invite).Clauses(clause.Returning{}).Error
+	return invite, err
+}
+
+func (i *InviteModel) GetAll(createdByID uuid.UUID, params InviteParams) (InviteSlice, int64, error) {
+	invites := InviteSlice{}
+	tx := i.db.
+		Model(&Invite{}).
+		Preload("CreatedBy")
+
+	if createdByID != uuid.Nil {
+		tx = tx.Where("created_by_id = ?", createdByID)
+	}
+
+	total := int64(0)
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := tx.Order("created_at desc").
+		Limit(params.Limit).
+		Offset(params.Offset).
+		Find(&invites).
+		Error
+
+	return invites, total, err
+}
+
+// Redeem uses up one use of the code, it fails with ErrRecordNotFound when
+// the code doesn't exist, was revoked, expired or has no uses left
+func (i *InviteModel) Redeem(code string) error {
+	res := i.db.Model(&Invite{}).
+		Where("code = ?", code).
+		Where("max_uses = 0 OR uses < max_uses").
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Update("uses", gorm.Expr("uses + 1"))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Revoke deletes the invite, only its creator can revoke unless createdByID
+// is nil
+func (i *InviteModel) Revoke(id, createdByID uuid.UUID) error {
+	tx := i.db.Where("id = ?", id)
+	if createdByID != uuid.Nil {
+		tx = tx.Where("created_by_id = ?", createdByID)
+	}
+
+	res := tx.Delete(&Invite{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package account
+
+import (
+	"fmt"
+	"maribooru/internal/helpers"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type (
+	// LoginAttempt tracks failed sign ins for one key, keys are prefixed with
+	// what they track (user:<id>, name:<name or email>, ip:<address>)
+	LoginAttempt struct {
+		Key           string `gorm:"primary_key"`
+		Failures      int    `gorm:"not null;default:0"`
+		LockedUntil   time.Time
+		LastFailureAt time.Time
+		CreatedAt     time.Time
+		UpdatedAt     time.Time
+	}
+
+	LoginAttemptSlice []LoginAttempt
+
+	LoginAttemptModel struct {
+		db *gorm.DB
+	}
+)
+
+func NewLoginAttemptModel(db *gorm.DB) *LoginAttemptModel {
+	return &LoginAttemptModel{
+		db: db,
+	}
+}
+
+func (l *LoginAttemptModel) Get(key string) (LoginAttempt, error) {
+	attempt := LoginAttempt{}
+	err := l.db.Model(&LoginAttempt{}).Where("key = ?", key).First(&attempt).Error
+	return attempt, err
+}
+
+// GetLockedUntil returns the furthest lockout among the keys, or the zero
+// time when none of them are locked
+func (l *LoginAttemptModel) GetLockedUntil(keys ...string) (time.Time, error) {
+	attempts := LoginAttemptSlice{}
+	err := l.db.Model(&LoginAttempt{}).
+		Where("key IN ? AND locked_until > ?", keys, time.Now()).
+		Find(&attempts).
+		Error
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	lockedUntil := time.Time{}
+	for _, attempt := range attempts {
+		if attempt.LockedUntil.After(lockedUntil) {
+			lockedUntil = attempt.LockedUntil
+		}
+	}
+	return lockedUntil, nil
+}
+
+// RegisterFailure increments the failure counter, counters whose last
+// failure is older than the window start over
+func (l *LoginAttemptModel) RegisterFailure(key string, window time.Duration) (LoginAttempt, error) {
+	now := time.Now()
+
+	err := l.db.Model(&LoginAttempt{}).
+		Where("key = ? AND last_failure_at < ? AND locked_until < ?", key, now.Add(-window), now).
+		Update("failures", 0).
+		Error
+	if err != nil {
+		return LoginAttempt{}, err
+	}
+
+	attempt := LoginAttempt{
+		Key:           key,
+		Failures:      1,
+		LastFailureAt: now,
+	}
+	err = l.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"failures":        gorm.Expr("login_attempts.failures + 1"),
+			"last_failure_at": now,
+			"updated_at":      now,
+		}),
+	}).Create(&attempt).Error
+	if err != nil {
+		return LoginAttempt{}, err
+	}
+
+	return l.Get(key)
+}
+
+func (l *LoginAttemptModel) Lock(key string, until time.Time) error {
+	return l.db.Model(&LoginAttempt{}).Where("key = ?", key).Update("locked_until", until).Error
+}
+
+func (l *LoginAttemptModel) Reset(key string) error {
+	res := l.db.Where("key = ?", key).Delete(&LoginAttempt{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (l *LoginAttemptModel) GetAllLocked(params helpers.GenericPagedQuery) (LoginAttemptSlice, int64, error) {
+	attempts := LoginAttemptSlice{}
+	tx := l.db.
+		Model(&LoginAttempt{}).
+		Where("locked_until > ?", time.Now()).
+		Where("key like ?", fmt.Sprintf("%%%s%%", params.Keywords))
+
+	total := int64(0)
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := tx.Order("locked_until desc").
+		Limit(params.Limit).
+		Offset(params.Offset).
+		Find(&attempts).
+		Error
+
+	return attempts, total, err
+}
@@ -2,16 +2,21 @@ package account
 
 import (
 	"errors"
+	"maribooru/internal/audit"
 	"maribooru/internal/config"
 	"maribooru/internal/helpers"
 	"maribooru/internal/permission"
+	"maribooru/internal/setting"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -27,24 +32,28 @@ type (
 	}
 
 	UserResponse struct {
-		ID         uuid.UUID        `json:"id"`
-		Name       string           `json:"name"`
-		Email      string           `json:"email,omitempty"`
-		CreatedAt  time.Time        `json:"created_at"`
-		UpdatedAt  time.Time        `json:"updated_at"`
-		Admin      bool             `json:"admin"`
-		Permission permission.Level `json:"permission"`
+		ID              uuid.UUID        `json:"id"`
+		Name            string           `json:"name"`
+		Email           string           `json:"email,omitempty"`
+		CreatedAt       time.Time        `json:"created_at"`
+		UpdatedAt       time.Time        `json:"updated_at"`
+		Admin           bool             `json:"admin"`
+		Permission      permission.Level `json:"permission"`
+		Roles           []string         `json:"roles"`
+		PendingApproval bool             `json:"pending_approval"`
 	}
 
 	UserParams struct {
 		helpers.GenericPagedQuery
 		IsAdmin bool `query:"is_admin"`
+		Pending bool `query:"pending"`
 	}
 
 	SignUp struct {
 		Name           string `json:"name" validate:"required"`
 		Email          string `json:"email" validate:"omitempty,email"`
 		Password       string `json:"password" validate:"required,min=8"`
+		InviteCode     string `json:"invite_code"`
 		HashedPassword string `json:"-"`
 	}
 
@@ -61,10 +70,13 @@ type (
 	}
 
 	UserHandler struct {
-		db    *gorm.DB
-		model *UserModel
-		cfg   *config.Config
-		log   *zap.Logger
+		db           *gorm.DB
+		model        *UserModel
+		attemptModel *LoginAttemptModel
+		cfg          *config.Config
+		log          *zap.Logger
+		dummyOnce    sync.Once
+		dummy        string
 	}
 )
 
@@ -106,8 +118,17 @@ func (u *User) ToResponse(includeEmail bool) UserResponse {
 		Name:       u.Name,
 		CreatedAt:  u.CreatedAt,
 		UpdatedAt:  u.UpdatedAt,
-		Admin:      u.Admin != (Admin{}),
 		Permission: u.Permission.Permission,
+		Roles:      make([]string, 0),
+
+		PendingApproval: u.PendingApproval,
+	}
+
+	for _, role := range u.Roles {
+		user.Roles = append(user.Roles, role.RoleName)
+		if role.RoleName == permission.RoleAdmin {
+			user.Admin = true
+		}
 	}
 
 	if includeEmail {
@@ -127,20 +148,35 @@ func (u UserSlice) ToResponse(includeEmail bool) []UserResponse {
 
 func NewUserHandler(db *gorm.DB, cfg *config.Config, log *zap.Logger) *UserHandler {
 	return &UserHandler{
-		db,
-		NewUserModel(db),
-		cfg,
-		log,
+		db:           db,
+		model:        NewUserModel(db),
+		attemptModel: NewLoginAttemptModel(db),
+		cfg:          cfg,
+		log:          log,
 	}
 }
 
 // INTERNAL CRUD ------------------------------- //
 
-func (u *UserHandler) create(c echo.Context, user User) error {
+func (u *UserHandler) create(c echo.Context, user User, inviteCode string) error {
 	u.log.Debug("UserHandler: Create")
 
-	data, err := u.model.Create(user)
+	// The invite is only used up when the user is actually created
+	tx := u.db.Begin()
+	if inviteCode != "" {
+		if err := NewInviteModel(tx).Redeem(inviteCode); err != nil {
+			tx.Rollback()
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return helpers.Response(c, http.StatusForbidden, nil, "Invite code is invalid, expired or used up")
+			}
+			u.log.Error("Failed to redeem invite", zap.Error(err))
+			return helpers.Response(c, http.StatusInternalServerError, nil, "There was an error while creating user")
+		}
+	}
+
+	data, err := NewUserModel(tx).Create(user)
 	if err != nil {
+		tx.Rollback()
 		if errors.Is(err, gorm.ErrDuplicatedKey) {
 			return helpers.Response(c, http.StatusConflict, nil, "User with this name/email already exists")
 		}
@@ -148,7 +184,16 @@ func (u *UserHandler) create(c echo.Context, user User) error {
 		return helpers.Response(c, http.StatusInternalServerError, nil, "There was an error while creating user")
 	}
 
-	token, err := helpers.GenerateJWT(data.ID, data.Name, u.cfg.JWT.Secret, u.cfg.AppConfig.TokenLifetime)
+	if err := tx.Commit().Error; err != nil {
+		u.log.Error("Failed to commit user", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "There was an error while creating user")
+	}
+
+	if data.PendingApproval {
+		return helpers.Response(c, http.StatusAccepted, data.ToResponse(true), "Account is awaiting approval")
+	}
+
+	token, err := helpers.GenerateJWT(data.ID, data.Name, u.cfg.JWT.Keys, u.cfg.AppConfig.TokenLifetime)
 	if err != nil {
 		u.log.Error("Error while generating token", zap.Error(err))
 		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to generate token")
@@ -203,30 +248,186 @@ func (u *UserHandler) getByID(c echo.Context, id uuid.UUID, includeEmail bool) e
 	return helpers.Response(c, http.StatusOK, data.ToResponse(includeEmail), "")
 }
 
-func (u *UserHandler) update(c echo.Context, user User) error {
+func (u *UserHandler) update(c echo.Context, user User, action string) error {
 	u.log.Debug("UserHandler: Update")
 
+	before, err := u.model.GetByID(user.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "User not found")
+		}
+		u.log.Debug("Failed to get user", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "There was an error while updating user")
+	}
+
 	data, err := u.model.Update(user)
 	if err != nil {
 		u.log.Debug("Failed to update user", zap.Error(err))
 		return helpers.Response(c, http.StatusInternalServerError, nil, "There was an error while updating user")
 	}
 
+	audit.Record(u.db, u.log, c, audit.Log{
+		ActorID:    user.ID,
+		Action:     action,
+		TargetType: audit.TargetUser,
+		TargetID:   user.ID.String(),
+		Before:     audit.ToJSON(before.ToResponse(true)),
+		After:      audit.ToJSON(data.ToResponse(true)),
+	})
+
 	return helpers.Response(c, http.StatusOK, data.ToResponse(true), "")
 }
 
 func (u *UserHandler) delete(c echo.Context, id uuid.UUID) error {
 	u.log.Debug("UserHandler: Delete")
 
+	before, err := u.model.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "User not found")
+		}
+		return helpers.Response(c, http.StatusInternalServerError, nil, "There was an error while deleting user")
+	}
+
 	if err := u.model.Delete(id); err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return helpers.Response(c, http.StatusNotFound, nil, "User not found")
 		}
 		return helpers.Response(c, http.StatusInternalServerError, nil, "There was an error while deleting user")
 	}
+
+	audit.Record(u.db, u.log, c, audit.Log{
+		ActorID:    id,
+		Action:     audit.ActionUserDeleted,
+		TargetType: audit.TargetUser,
+		TargetID:   id.String(),
+		Before:     audit.ToJSON(before.ToResponse(true)),
+	})
+
 	return helpers.Response(c, http.StatusOK, nil, "")
 }
 
+// rehashPassword upgrades a hash made with an older algorithm or weaker
+// parameters, failures are only logged since the sign in itself succeeded
+func (u *UserHandler) rehashPassword(user User, password string) {
+	hasher := u.cfg.Password.Hasher()
+	if !hasher.NeedsRehash(user.Password) {
+		return
+	}
+
+	hashedPassword, err := hasher.Hash(password)
+	if err != nil {
+		u.log.Error("Error while rehashing password", zap.Error(err))
+		return
+	}
+
+	if _, err := u.model.Update(User{ID: user.ID, Password: hashedPassword}); err != nil {
+		u.log.Error("Failed to store rehashed password", zap.Error(err))
+		return
+	}
+
+	u.log.Debug("Upgraded password hash", zap.Any("user", user.ID))
+}
+
+func (u *UserHandler) failSignIn(c echo.Context, user User, nameOrEmail, accountKey, ipKey string) error {
+	u.audit(c, user.ID, audit.ActionSignInFailed, map[string]interface{}{
+		"name_or_email": nameOrEmail,
+	})
+
+	lockedUntil := time.Time{}
+	for key, threshold := range map[string]int{
+		accountKey: u.cfg.Lockout.MaxAttempts,
+		ipKey:      u.cfg.Lockout.MaxAttemptsIP,
+	} {
+		attempt, err := u.attemptModel.RegisterFailure(key, u.cfg.Lockout.Window)
+		if err != nil {
+			u.log.Error("Failed to register failed sign in", zap.Error(err))
+			continue
+		}
+
+		duration := lockoutDuration(attempt.Failures, threshold, u.cfg.Lockout)
+		if duration == 0 {
+			continue
+		}
+
+		until := time.Now().Add(duration)
+		if err := u.attemptModel.Lock(key, until); err != nil {
+			u.log.Error("Failed to lock sign in", zap.Error(err))
+			continue
+		}
+
+		u.audit(c, user.ID, audit.ActionSignInLocked, map[string]interface{}{
+			"key":          key,
+			"failures":     attempt.Failures,
+			"locked_until": until,
+		})
+
+		if until.After(lockedUntil) {
+			lockedUntil = until
+		}
+	}
+
+	if !lockedUntil.IsZero() {
+		return u.tooManyAttempts(c, lockedUntil)
+	}
+
+	return helpers.Response(c, http.StatusUnauthorized, nil, "Invalid credentials")
+}
+
+func (u *UserHandler) tooManyAttempts(c echo.Context, lockedUntil time.Time) error {
+	retryAfter := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	return helpers.Response(c, http.StatusTooManyRequests, nil, "Too many failed attempts, try again later")
+}
+
+func (u *UserHandler) audit(c echo.Context, userID uuid.UUID, action string, detail map[string]interface{}) {
+	entry := audit.Log{
+		ActorID:    userID,
+		Action:     action,
+		TargetType: audit.TargetUser,
+	}
+	if userID != uuid.Nil {
+		entry.TargetID = userID.String()
+	}
+	if detail != nil {
+		entry.Detail = audit.ToJSON(detail)
+	}
+
+	audit.Record(u.db, u.log, c, entry)
+}
+
+// dummyHash is compared against when the user doesn't exist so that unknown
+// names take as long to reject as wrong passwords
+func (u *UserHandler) dummyHash() string {
+	u.dummyOnce.Do(func() {
+		hash, err := u.cfg.Password.Hasher().Hash(uuid.NewString())
+		if err != nil {
+			u.log.Error("Failed to generate dummy hash", zap.Error(err))
+		}
+		u.dummy = hash
+	})
+	return u.dummy
+}
+
+// lockoutDuration doubles with every failure past the threshold, a threshold
+// of zero disables the lockout
+func lockoutDuration(failures, threshold int, cfg config.Lockout) time.Duration {
+	if threshold <= 0 || failures < threshold {
+		return 0
+	}
+
+	exponent := failures - threshold
+	if exponent > 30 {
+		return cfg.MaxDuration
+	}
+
+	duration := cfg.BaseDuration << exponent
+	if duration <= 0 || duration > cfg.MaxDuration {
+		return cfg.MaxDuration
+	}
+	return duration
+}
+
 // END OF INTERNAL CRUD ------------------------ //
 
 func (u *UserHandler) SignUp(c echo.Context) error {
@@ -246,7 +447,24 @@ func (u *UserHandler) SignUp(c echo.Context) error {
 		}
 	}
 
-	hashedPassword, err := helpers.PasswordHash(request.Password)
+	mode, err := setting.NewModel(u.db).GetRegistrationMode()
+	if err != nil {
+		u.log.Error("Failed to get registration mode", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "There was an error while creating user")
+	}
+
+	inviteCode := ""
+	switch mode {
+	case setting.RegistrationClosed:
+		return helpers.Response(c, http.StatusForbidden, nil, "Registration is closed")
+	case setting.RegistrationInvite:
+		if request.InviteCode == "" {
+			return helpers.Response(c, http.StatusForbidden, nil, "An invite code is required to register")
+		}
+		inviteCode = request.InviteCode
+	}
+
+	hashedPassword, err := u.cfg.Password.Hasher().Hash(request.Password)
 	if err != nil {
 		u.log.Error("Error while hashing password", zap.Error(err))
 		return helpers.Response(c, http.StatusInternalServerError, nil, "There was an error while hashing password")
@@ -262,8 +480,10 @@ func (u *UserHandler) SignUp(c echo.Context) error {
 	}
 
 	user.Permission = userPermission
+	user.Roles = permission.NewUserRoles(userPermission.Permission.Roles()...)
+	user.PendingApproval = mode == setting.RegistrationApproval
 
-	return u.create(c, user)
+	return u.create(c, user, inviteCode)
 }
 
 func (u *UserHandler) SignIn(c echo.Context) error {
@@ -277,21 +497,72 @@ func (u *UserHandler) SignIn(c echo.Context) error {
 		return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
 	}
 
+	ip := c.RealIP()
+	ipKey := "ip:" + ip
+
+	// Unknown names are tracked just like real accounts, so lockouts can't be
+	// used to find out which accounts exist
 	data, err := u.model.GetByNameOrEmail(request.NameOrEmail)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		u.log.Error("Failed to get user", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to sign in")
+	}
+	accountKey := "name:" + strings.ToLower(request.NameOrEmail)
+	if err == nil {
+		accountKey = "user:" + data.ID.String()
+	}
+
+	lockedUntil, err := u.attemptModel.GetLockedUntil(accountKey, ipKey)
 	if err != nil {
-		return helpers.Response(c, http.StatusBadRequest, nil, "User not found")
+		u.log.Error("Failed to check sign in lockout", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to sign in")
+	}
+	if !lockedUntil.IsZero() {
+		u.audit(c, data.ID, audit.ActionSignInBlocked, map[string]interface{}{
+			"name_or_email": request.NameOrEmail,
+			"locked_until":  lockedUntil,
+		})
+		return u.tooManyAttempts(c, lockedUntil)
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(data.Password), []byte(request.Password)); err != nil {
-		u.log.Error("Error while comparing passwords", zap.Error(err))
-		return helpers.Response(c, http.StatusUnauthorized, nil, "Invalid credentials")
+	if data.ID == uuid.Nil {
+		// Burn the same time a real comparison would take
+		helpers.PasswordVerify(u.dummyHash(), request.Password)
+		return u.failSignIn(c, data, request.NameOrEmail, accountKey, ipKey)
+	}
+
+	if err := helpers.PasswordVerify(data.Password, request.Password); err != nil {
+		u.log.Debug("Error while comparing passwords", zap.Error(err))
+		return u.failSignIn(c, data, request.NameOrEmail, accountKey, ipKey)
+	}
+
+	if err := u.attemptModel.Reset(accountKey); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		u.log.Warn("Failed to reset sign in attempts", zap.Error(err))
 	}
 
-	token, err := helpers.GenerateJWT(data.ID, data.Name, u.cfg.JWT.Secret, u.cfg.AppConfig.TokenLifetime)
+	if data.PendingApproval {
+		u.audit(c, data.ID, audit.ActionSignInPending, nil)
+		return helpers.Response(c, http.StatusForbidden, nil, "Account is awaiting approval")
+	}
+
+	if ban, err := NewBanModel(u.db).GetActive(data.ID); err == nil {
+		u.audit(c, data.ID, audit.ActionSignInBanned, map[string]interface{}{
+			"ban_id": ban.ID,
+		})
+		return helpers.Response(c, http.StatusForbidden, ban.ToPublicResponse(), BanMessage(ban))
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		u.log.Error("Failed to check bans", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to sign in")
+	}
+
+	u.rehashPassword(data, request.Password)
+
+	token, err := helpers.GenerateJWT(data.ID, data.Name, u.cfg.JWT.Keys, u.cfg.AppConfig.TokenLifetime)
 	if err != nil {
 		u.log.Error("Failed to generate token", zap.Error(err))
 		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to generate token")
 	}
+	u.audit(c, data.ID, audit.ActionSignIn, nil)
 
 	return helpers.Response(c, http.StatusOK, token, "")
 }
@@ -303,7 +574,7 @@ func (u *UserHandler) GetAllUsers(c echo.Context) error {
 
 func (u *UserHandler) SelfGet(c echo.Context) error {
 	u.log.Debug("UserHandler: GetSelf")
-	id, err := helpers.GetUserID(c, u.cfg.JWT.Secret)
+	id, err := helpers.GetUserID(c, u.cfg.JWT.Keys)
 	if err != nil {
 		return helpers.Response(c, http.StatusBadRequest, nil, "Failed to get your details")
 	}
@@ -322,7 +593,7 @@ func (u *UserHandler) GetUserByID(c echo.Context) error {
 
 func (u *UserHandler) ChangePassword(c echo.Context) error {
 	u.log.Debug("UserHandler: ChangePassword")
-	id, err := helpers.GetUserID(c, u.cfg.JWT.Secret)
+	id, err := helpers.GetUserID(c, u.cfg.JWT.Keys)
 	if err != nil {
 		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
 	}
@@ -344,12 +615,12 @@ func (u *UserHandler) ChangePassword(c echo.Context) error {
 		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to change password")
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.OldPassword)); err != nil {
+	if err := helpers.PasswordVerify(user.Password, request.OldPassword); err != nil {
 		u.log.Error("Error while comparing passwords", zap.Error(err))
 		return helpers.Response(c, http.StatusUnauthorized, nil, "Old and new password don't match")
 	}
 
-	hashedPassword, err := helpers.PasswordHash(request.NewPassword)
+	hashedPassword, err := u.cfg.Password.Hasher().Hash(request.NewPassword)
 	if err != nil {
 		u.log.Error("Error while hashing password", zap.Error(err))
 		return helpers.Response(c, http.StatusInternalServerError, nil, "There was an error while hashing password")
@@ -357,11 +628,11 @@ func (u *UserHandler) ChangePassword(c echo.Context) error {
 
 	user.Password = hashedPassword
 
-	return u.update(c, user)
+	return u.update(c, user, audit.ActionUserPasswordChanged)
 }
 
 func (u *UserHandler) SelfUpdate(c echo.Context) error {
-	id, err := helpers.GetUserID(c, u.cfg.JWT.Secret)
+	id, err := helpers.GetUserID(c, u.cfg.JWT.Keys)
 	if err != nil {
 		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
 	}
@@ -379,12 +650,12 @@ func (u *UserHandler) SelfUpdate(c echo.Context) error {
 		return helpers.Response(c, http.StatusBadRequest, nil, "Nothing to update")
 	}
 
-	return u.update(c, request.ToTable(id))
+	return u.update(c, request.ToTable(id), audit.ActionUserUpdated)
 }
 
 func (u *UserHandler) SelfDelete(c echo.Context) error {
 	u.log.Debug("UserHandler: SelfDelete")
-	id, err := helpers.GetUserID(c, u.cfg.JWT.Secret)
+	id, err := helpers.GetUserID(c, u.cfg.JWT.Keys)
 	if err != nil {
 		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
 	}
@@ -0,0 +1,184 @@
+package account
+
+import (
+	"encoding/json"
+	"maribooru/internal/audit"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"maribooru/internal/permission"
+	"maribooru/internal/setting"
+	"maribooru/internal/validation"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gopkg.in/go-playground/assert.v1"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestSignInLockout(t *testing.T) {
+	e := echo.New()
+	e.Validator = validation.NewValidator(validator.New())
+	e.IPExtractor = echo.ExtractIPDirect()
+
+	cfg := &config.Config{
+		AppConfig: config.AppConfig{TokenLifetime: time.Minute},
+		JWT:       config.JWT{Secret: "secret", Keys: helpers.NewHMACKeys("secret")},
+		Password:  config.Password{Algorithm: helpers.AlgorithmBcrypt, BcryptCost: 4},
+		Lockout: config.Lockout{
+			MaxAttempts:   3,
+			MaxAttemptsIP: 100,
+			BaseDuration:  time.Minute,
+			MaxDuration:   time.Hour,
+			Window:        time.Hour,
+		},
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(User{}, Admin{}, permission.Permission{}, permission.Role{}, permission.RoleCapability{}, permission.UserRole{}, Ban{}, LoginAttempt{}, audit.Log{})
+
+	log, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pass, err := cfg.Password.Hasher().Hash("unittest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := NewUserModel(db).Create(User{Name: "lockout", Password: pass})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewUserHandler(db, cfg, log)
+	signIn := func(name, password string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(SignIn{NameOrEmail: name, Password: password})
+		req := httptest.NewRequest(echo.POST, "/", strings.NewReader(string(body)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		if err := handler.SignIn(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	unknown := signIn("nobody", "wrong-password")
+	wrong := signIn("lockout", "wrong-password")
+	assert.Equal(t, http.StatusUnauthorized, unknown.Code)
+	assert.Equal(t, unknown.Code, wrong.Code)
+	assert.Equal(t, unknown.Body.String(), wrong.Body.String())
+
+	signIn("lockout", "wrong-password")
+	rec := signIn("lockout", "wrong-password")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEqual(t, "", rec.Header().Get("Retry-After"))
+
+	// The correct password is rejected while locked
+	rec = signIn("lockout", "unittest")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	if err := NewLoginAttemptModel(db).Reset("user:" + user.ID.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = signIn("lockout", "unittest")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var failures int64
+	db.Model(&audit.Log{}).Where("action = ?", audit.ActionSignInFailed).Count(&failures)
+	assert.Equal(t, int64(4), failures)
+
+	var withoutIP int64
+	db.Model(&audit.Log{}).Where("action = ? AND ip = ?", audit.ActionSignInFailed, "").Count(&withoutIP)
+	assert.Equal(t, int64(0), withoutIP)
+}
+
+func TestLockoutDuration(t *testing.T) {
+	cfg := config.Lockout{
+		BaseDuration: time.Minute,
+		MaxDuration:  time.Hour,
+	}
+
+	assert.Equal(t, time.Duration(0), lockoutDuration(2, 3, cfg))
+	assert.Equal(t, time.Minute, lockoutDuration(3, 3, cfg))
+	assert.Equal(t, 4*time.Minute, lockoutDuration(5, 3, cfg))
+	assert.Equal(t, time.Hour, lockoutDuration(20, 3, cfg))
+	assert.Equal(t, time.Hour, lockoutDuration(1000, 3, cfg))
+	assert.Equal(t, time.Duration(0), lockoutDuration(1000, 0, cfg))
+}
+
+func TestSignUpRegistrationModes(t *testing.T) {
+	e := echo.New()
+	e.Validator = validation.NewValidator(validator.New())
+
+	cfg := &config.Config{
+		AppConfig: config.AppConfig{TokenLifetime: time.Minute},
+		JWT:       config.JWT{Secret: "secret", Keys: helpers.NewHMACKeys("secret")},
+		Password:  config.Password{Algorithm: helpers.AlgorithmBcrypt, BcryptCost: 4},
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(User{}, Admin{}, permission.Permission{}, permission.Role{}, permission.RoleCapability{}, permission.UserRole{}, Ban{}, setting.AppSetting{}, Invite{}, LoginAttempt{}, audit.Log{})
+	db.Create(&setting.AppSetting{Key: setting.KeyRegistrationMode, ValueString: setting.RegistrationOpen})
+
+	log, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewUserHandler(db, cfg, log)
+	setMode := func(mode string) {
+		if err := setting.NewModel(db).Update(setting.AppSetting{Key: setting.KeyRegistrationMode, ValueString: mode}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	signUp := func(name, inviteCode string) int {
+		body, _ := json.Marshal(SignUp{Name: name, Password: "unittest", InviteCode: inviteCode})
+		req := httptest.NewRequest(echo.POST, "/", strings.NewReader(string(body)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		if err := handler.SignUp(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code
+	}
+
+	setMode(setting.RegistrationClosed)
+	assert.Equal(t, http.StatusForbidden, signUp("closed", ""))
+
+	inviter, err := NewUserModel(db).Create(User{Name: "inviter", Password: "-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	invite, err := NewInviteModel(db).Create(Invite{Code: "single-use", MaxUses: 1, CreatedByID: inviter.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setMode(setting.RegistrationInvite)
+	assert.Equal(t, http.StatusForbidden, signUp("no-invite", ""))
+	assert.Equal(t, http.StatusOK, signUp("invited", invite.Code))
+	assert.Equal(t, http.StatusForbidden, signUp("invited-again", invite.Code))
+
+	setMode(setting.RegistrationApproval)
+	assert.Equal(t, http.StatusAccepted, signUp("pending", ""))
+
+	pending, err := NewUserModel(db).GetByNameOrEmail("pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, true, pending.PendingApproval)
+}
@@ -20,8 +20,13 @@ type (
 		CreatedAt  time.Time
 		UpdatedAt  time.Time
 		DeletedAt  gorm.DeletedAt
-		Admin      Admin
 		Permission permission.Permission
+		Roles      []permission.UserRole `gorm:"foreignKey:UserID"`
+
+		PendingApproval bool `gorm:"not null;default:false"`
+		// NoPassword marks accounts provisioned through OIDC, their password
+		// is random and unknown so an identity is the only way in
+		NoPassword bool `gorm:"not null;default:false"`
 	}
 
 	UserSlice []User
@@ -52,12 +57,16 @@ func (u *UserModel) GetAll(params UserParams) (UserSlice, int64, error) {
 	users := []User{}
 	tx := u.db.
 		Model(&User{}).
-		Preload("Admin").
 		Preload("Permission").
+		Preload("Roles").
 		Where("name ilike ?", fmt.Sprintf("%%%s%%", params.Keywords))
 
 	if params.IsAdmin {
-		tx = tx.InnerJoins("Admin")
+		tx = tx.Where("EXISTS (SELECT 1 FROM user_roles WHERE user_roles.user_id = users.id AND user_roles.role_name = ?)", permission.RoleAdmin)
+	}
+
+	if params.Pending {
+		tx = tx.Where("pending_approval = ?", true)
 	}
 
 	total := int64(0)
@@ -74,7 +83,7 @@ func (u *UserModel) GetAll(params UserParams) (UserSlice, int64, error) {
 
 func (u *UserModel) GetByID(id uuid.UUID) (User, error) {
 	user := User{}
-	err := u.db.Model(&User{}).Preload("Admin").Preload("Permission").First(&user, id).Error
+	err := u.db.Model(&User{}).Preload("Permission").Preload("Roles").First(&user, id).Error
 	return user, err
 }
 
@@ -98,6 +107,17 @@ func (u *UserModel) Update(user User) (User, error) {
 	return u.GetByID(user.ID)
 }
 
+func (u *UserModel) SetPendingApproval(id uuid.UUID, pending bool) error {
+	res := u.db.Model(&User{}).Where("id = ?", id).Update("pending_approval", pending)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 func (u *UserModel) Delete(id uuid.UUID) error {
 	user, err := u.GetByID(id)
 	if err != nil {
@@ -106,12 +126,9 @@ func (u *UserModel) Delete(id uuid.UUID) error {
 
 	tx := u.db.Begin()
 
-	if user.Admin != (Admin{}) {
-		res := tx.Model(&Admin{}).Delete(&Admin{}, user.Admin.ID)
-		if res.RowsAffected == 0 {
-			tx.Rollback()
-			return errors.New("Unable to delete admin position")
-		}
+	if err := tx.Where("user_id = ?", id).Delete(&Admin{}).Error; err != nil {
+		tx.Rollback()
+		return err
 	}
 
 	if user.Permission != (permission.Permission{}) {
@@ -122,6 +139,11 @@ func (u *UserModel) Delete(id uuid.UUID) error {
 		}
 	}
 
+	if err := permission.NewRoleModel(tx).TrashUserRoles(id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	res := tx.Model(&User{}).Delete(&User{}, id)
 	if res.RowsAffected == 0 {
 		tx.Rollback()
@@ -0,0 +1,182 @@
+package asset
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+type (
+	etag struct {
+		size    int64
+		modTime time.Time
+		value   string
+	}
+
+	Handler struct {
+		cfg   *config.Config
+		log   *zap.Logger
+		mu    sync.Mutex
+		etags map[string]etag
+	}
+)
+
+const (
+	// A file named after the hash of its content never changes what it
+	// serves, so clients can keep it for good
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// Anything else has to be revalidated against the ETag
+	revalidateCacheControl = "no-cache"
+
+	// maxETags bounds how many hashes are remembered
+	maxETags = 4096
+)
+
+func NewHandler(cfg *config.Config, log *zap.Logger) *Handler {
+	return &Handler{
+		cfg:   cfg,
+		log:   log,
+		etags: map[string]etag{},
+	}
+}
+
+// Serve streams a file from local asset storage, Range and conditional
+// requests are answered by http.ServeContent. With S3 storage it answers 501,
+// redirecting to presigned URLs is not done yet and the config has no bucket
+// to sign for.
+func (a *Handler) Serve(c echo.Context) error {
+	a.log.Debug("AssetHandler: Serve")
+	if a.cfg.AssetStorage.UseS3 {
+		return helpers.Response(c, http.StatusNotImplemented, nil, "Asset serving is not available with S3 storage")
+	}
+	name := path.Clean("/" + c.Param("*"))
+	if name == "/" || hidden(name) {
+		return helpers.Response(c, http.StatusNotFound, nil, "Asset not found")
+	}
+
+	resolved, err := a.resolve(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Asset not found")
+		}
+		a.log.Error("Failed to resolve asset", zap.String("name", name), zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get asset")
+	}
+
+	file, err := os.Open(resolved)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Asset not found")
+		}
+		a.log.Error("Failed to open asset", zap.String("name", name), zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get asset")
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		a.log.Error("Failed to stat asset", zap.String("name", name), zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get asset")
+	}
+	if info.IsDir() {
+		return helpers.Response(c, http.StatusNotFound, nil, "Asset not found")
+	}
+
+	tag, err := a.etag(name, file, info)
+	if err != nil {
+		a.log.Error("Failed to hash asset", zap.String("name", name), zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get asset")
+	}
+
+	header := c.Response().Header()
+	header.Set("ETag", tag)
+	if contentAddressed(name, tag) {
+		header.Set("Cache-Control", immutableCacheControl)
+	} else {
+		header.Set("Cache-Control", revalidateCacheControl)
+	}
+	http.ServeContent(c.Response(), c.Request(), info.Name(), info.ModTime(), file)
+	return nil
+}
+
+// hidden reports whether any segment of the name is a dotfile
+func hidden(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve follows symlinks in the asset path, refusing anything that ends
+// up outside of the storage root
+func (a *Handler) resolve(name string) (string, error) {
+	root, err := filepath.EvalSymlinks(a.cfg.AssetStorage.Path)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return "", err
+	}
+	relative, err := filepath.Rel(root, resolved)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", fs.ErrNotExist
+	}
+	return resolved, nil
+}
+
+// contentAddressed reports whether the file is named after the hash of its
+// content, e.g. "<sha256>.png"
+func contentAddressed(name, tag string) bool {
+	base := path.Base(name)
+	stem := strings.TrimSuffix(base, path.Ext(base))
+	return `"`+strings.ToLower(stem)+`"` == tag
+}
+
+// etag hashes the file content once and reuses it until the file changes
+func (a *Handler) etag(name string, file *os.File, info fs.FileInfo) (string, error) {
+	a.mu.Lock()
+	cached, ok := a.etags[name]
+	a.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.value, nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	value := `"` + hex.EncodeToString(hash.Sum(nil)) + `"`
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.etags[name]; !ok && len(a.etags) >= maxETags {
+		// Drop whichever entry comes first, it's only a cache
+		for key := range a.etags {
+			delete(a.etags, key)
+			break
+		}
+	}
+	a.etags[name] = etag{size: info.Size(), modTime: info.ModTime(), value: value}
+	return value, nil
+}
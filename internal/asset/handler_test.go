@@ -0,0 +1,98 @@
+package asset
+
+import (
+	"maribooru/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestServe(t *testing.T) {
+	e := echo.New()
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "originals"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	const hash = "84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882"
+	for _, name := range []string{"image.png", hash + ".png", ".secret"} {
+		if err := os.WriteFile(filepath.Join(root, "originals", name), []byte("0123456789"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outside := filepath.Join(t.TempDir(), "outside.txt")
+	if err := os.WriteFile(outside, []byte("outside"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "originals", "escape.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("image.png", filepath.Join(root, "originals", "alias.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewHandler(&config.Config{AssetStorage: config.AssetStorage{Path: root}}, log)
+	serve := func(name string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.GET, "/data/"+name, nil)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("*")
+		c.SetParamValues(name)
+		if err := handler.Serve(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	rec := serve("originals/image.png", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "0123456789", rec.Body.String())
+	assert.Equal(t, revalidateCacheControl, rec.Header().Get("Cache-Control"))
+	tag := rec.Header().Get("ETag")
+	assert.Equal(t, `"`+hash+`"`, tag)
+
+	// Only a file named after its own hash is cached for good
+	rec = serve("originals/"+hash+".png", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, immutableCacheControl, rec.Header().Get("Cache-Control"))
+
+	rec = serve("originals/image.png", http.Header{"Range": {"bytes=2-5"}})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "2345", rec.Body.String())
+	assert.Equal(t, "bytes 2-5/10", rec.Header().Get("Content-Range"))
+
+	assert.Equal(t, http.StatusNotModified, serve("originals/image.png", http.Header{"If-None-Match": {tag}}).Code)
+	assert.Equal(t, http.StatusNotFound, serve("originals/missing.png", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve("originals", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve("../../etc/passwd", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve("originals/.secret", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve("originals/escape.txt", nil).Code)
+	assert.Equal(t, http.StatusOK, serve("originals/alias.png", nil).Code)
+
+	// The hash cache stays bounded
+	delete(handler.etags, "/originals/image.png")
+	for i := 0; len(handler.etags) < maxETags; i++ {
+		handler.etags[strconv.Itoa(i)] = etag{}
+	}
+	assert.Equal(t, http.StatusOK, serve("originals/image.png", nil).Code)
+	assert.Equal(t, maxETags, len(handler.etags))
+
+	// S3 storage isn't served yet and says so instead of a 404
+	handler.cfg.AssetStorage.UseS3 = true
+	assert.Equal(t, http.StatusNotImplemented, serve("originals/image.png", nil).Code)
+}
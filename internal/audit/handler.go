@@ -0,0 +1,112 @@
+package audit
+
+import (
+	"encoding/json"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type (
+	Params struct {
+		helpers.GenericPagedQuery
+		ActorID    uuid.UUID `query:"actor_id"`
+		Action     string    `query:"action"`
+		TargetType string    `query:"target_type"`
+		TargetID   string    `query:"target_id"`
+		IP         string    `query:"ip"`
+		From       time.Time `query:"from"`
+		To         time.Time `query:"to"`
+	}
+
+	Response struct {
+		ID         uuid.UUID       `json:"id"`
+		ActorID    uuid.UUID       `json:"actor_id"`
+		ActorName  string          `json:"actor_name"`
+		Action     string          `json:"action"`
+		TargetType string          `json:"target_type"`
+		TargetID   string          `json:"target_id"`
+		IP         string          `json:"ip"`
+		Detail     json.RawMessage `json:"detail"`
+		Before     json.RawMessage `json:"before"`
+		After      json.RawMessage `json:"after"`
+		CreatedAt  time.Time       `json:"created_at"`
+	}
+
+	Handler struct {
+		db    *gorm.DB
+		model *Model
+		cfg   *config.Config
+		log   *zap.Logger
+	}
+)
+
+func (l *Log) ToResponse() Response {
+	return Response{
+		ID:         l.ID,
+		ActorID:    l.ActorID,
+		ActorName:  l.ActorName,
+		Action:     l.Action,
+		TargetType: l.TargetType,
+		TargetID:   l.TargetID,
+		IP:         l.IP,
+		Detail:     rawJSON(l.Detail),
+		Before:     rawJSON(l.Before),
+		After:      rawJSON(l.After),
+		CreatedAt:  l.CreatedAt,
+	}
+}
+
+func (l LogSlice) ToResponse() []Response {
+	response := make([]Response, 0)
+	for _, log := range l {
+		response = append(response, log.ToResponse())
+	}
+	return response
+}
+
+// rawJSON embeds stored JSON as is, with empty columns rendered as null
+func rawJSON(s string) json.RawMessage {
+	if s == "" {
+		return nil
+	}
+	return json.RawMessage(s)
+}
+
+func NewHandler(db *gorm.DB, cfg *config.Config, log *zap.Logger) *Handler {
+	return &Handler{
+		db:    db,
+		model: NewModel(db),
+		cfg:   cfg,
+		log:   log,
+	}
+}
+
+func (a *Handler) GetAll(c echo.Context) error {
+	a.log.Debug("AuditHandler: GetAll")
+	params := Params{
+		GenericPagedQuery: helpers.GenericPagedQuery{
+			Limit:  50,
+			Offset: 0,
+		},
+	}
+	if err := c.Bind(&params); err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "Invalid request")
+	}
+
+	data, total, err := a.model.GetAll(params)
+	if err != nil {
+		a.log.Error("Failed to get audit log", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get audit log")
+	}
+
+	paged := helpers.PageData(data.ToResponse(), int(total), params.Offset, params.Limit)
+
+	return helpers.Response(c, http.StatusOK, paged, "")
+}
@@ -0,0 +1,83 @@
+package audit_test
+
+import (
+	"encoding/json"
+	"maribooru/internal/account"
+	"maribooru/internal/audit"
+	"maribooru/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gopkg.in/go-playground/assert.v1"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestAuditFilters(t *testing.T) {
+	e := echo.New()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(account.User{}, audit.Log{})
+
+	log, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actor := account.User{Name: "auditor", Password: "-"}
+	if err := db.Create(&actor).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	model := audit.NewModel(db)
+	entries := []audit.Log{
+		{ActorID: actor.ID, Action: audit.ActionTagCreated, TargetType: audit.TargetTag, TargetID: "a", After: audit.ToJSON(map[string]string{"slug": "a"})},
+		{ActorID: actor.ID, Action: audit.ActionTagUpdated, TargetType: audit.TargetTag, TargetID: "a", Before: audit.ToJSON(map[string]string{"slug": "a"}), After: audit.ToJSON(map[string]string{"slug": "b"})},
+		{ActorID: actor.ID, Action: audit.ActionRoleCreated, TargetType: audit.TargetRole, TargetID: "helper"},
+		{Action: audit.ActionSignInFailed, TargetType: audit.TargetUser, IP: "192.0.2.1", CreatedAt: time.Now().Add(-48 * time.Hour)},
+	}
+	for _, entry := range entries {
+		if err := model.Create(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler := audit.NewHandler(db, &config.Config{}, log)
+	get := func(query url.Values) []audit.Response {
+		req := httptest.NewRequest(echo.GET, "/?"+query.Encode(), nil)
+		rec := httptest.NewRecorder()
+		if err := handler.GetAll(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		body := struct {
+			Data struct {
+				List []audit.Response `json:"list"`
+			} `json:"data"`
+		}{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body.Data.List
+	}
+
+	tags := get(url.Values{"action": {"tag."}})
+	assert.Equal(t, 2, len(tags))
+	assert.Equal(t, audit.ActionTagUpdated, tags[0].Action)
+	assert.Equal(t, "auditor", tags[0].ActorName)
+	assert.Equal(t, `{"slug":"b"}`, string(tags[0].After))
+
+	assert.Equal(t, 3, len(get(url.Values{"actor_id": {actor.ID.String()}})))
+	assert.Equal(t, 1, len(get(url.Values{"target_type": {audit.TargetRole}})))
+	assert.Equal(t, 3, len(get(url.Values{"from": {time.Now().Add(-time.Hour).Format(time.RFC3339)}})))
+	assert.Equal(t, 1, len(get(url.Values{"to": {time.Now().Add(-time.Hour).Format(time.RFC3339)}})))
+}
@@ -0,0 +1,185 @@
+package audit
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type (
+	Log struct {
+		ID         uuid.UUID `gorm:"primary_key;type:uuid"`
+		ActorID    uuid.UUID `gorm:"type:uuid;default:null;index"`
+		Action     string    `gorm:"not null;index"`
+		TargetType string    `gorm:"index"`
+		TargetID   string    `gorm:"index"`
+		IP         string
+		Detail     string `gorm:"type:text"`
+		Before     string `gorm:"type:text"`
+		After      string `gorm:"type:text"`
+		CreatedAt  time.Time
+
+		// Filled by a join on users, audit can't depend on account
+		ActorName string `gorm:"->;-:migration"`
+	}
+
+	LogSlice []Log
+
+	Model struct {
+		db *gorm.DB
+	}
+)
+
+const (
+	ActionSignIn         = "sign_in"
+	ActionSignInFailed   = "sign_in.failed"
+	ActionSignInBlocked  = "sign_in.blocked"
+	ActionSignInLocked   = "sign_in.locked"
+	ActionSignInUnlocked = "sign_in.unlocked"
+	ActionSignInPending  = "sign_in.pending"
+	ActionSignInBanned   = "sign_in.banned"
+	ActionUserBanned     = "user.banned"
+	ActionUserSuspended  = "user.suspended"
+	ActionUserUnbanned   = "user.unbanned"
+
+	ActionUserUpdated         = "user.updated"
+	ActionUserDeleted         = "user.deleted"
+	ActionUserPasswordChanged = "user.password_changed"
+	ActionUserApproved        = "user.approved"
+	ActionUserRejected        = "user.rejected"
+	ActionUserRolesSet        = "user.roles_set"
+	ActionAdminCreated        = "admin.created"
+	ActionAdminAssigned       = "admin.assigned"
+	ActionAdminRemoved        = "admin.removed"
+	ActionPermissionSet       = "permission.set"
+	ActionRoleCreated         = "role.created"
+	ActionRoleUpdated         = "role.updated"
+	ActionRoleDeleted         = "role.deleted"
+	ActionTagCreated          = "tag.created"
+	ActionTagUpdated          = "tag.updated"
+	ActionTagDeleted          = "tag.deleted"
+	ActionCategoryCreated     = "tag_category.created"
+	ActionCategoryUpdated     = "tag_category.updated"
+	ActionCategoryDeleted     = "tag_category.deleted"
+	ActionSettingsUpdated     = "settings.updated"
+	ActionInviteCreated       = "invite.created"
+	ActionInviteRevoked       = "invite.revoked"
+	ActionReportResolved      = "report.resolved"
+	ActionReportDismissed     = "report.dismissed"
+	ActionIdentityUnlinked    = "identity.unlinked"
+	ActionArtistUpdated       = "artist.updated"
+	ActionArtistDeleted       = "artist.deleted"
+	ActionTrashRestored       = "trash.restored"
+	ActionTrashPurged         = "trash.purged"
+)
+
+const (
+	TargetUser     = "user"
+	TargetIP       = "ip"
+	TargetRole     = "role"
+	TargetTag      = "tag"
+	TargetCategory = "tag_category"
+	TargetSettings = "settings"
+	TargetInvite   = "invite"
+	TargetReport   = "report"
+	TargetIdentity = "identity"
+	TargetArtist   = "artist"
+)
+
+func (Log) TableName() string {
+	return "audit_logs"
+}
+
+func (l *Log) BeforeCreate(tx *gorm.DB) error {
+	l.ID = uuid.New()
+	return nil
+}
+
+func NewModel(db *gorm.DB) *Model {
+	return &Model{
+		db: db,
+	}
+}
+
+// Create appends an entry, the audit log is never updated or deleted from
+func (m *Model) Create(log Log) error {
+	return m.db.Create(&log).Error
+}
+
+func (m *Model) GetAll(params Params) (LogSlice, int64, error) {
+	logs := LogSlice{}
+	tx := m.db.
+		Model(&Log{}).
+		Select("audit_logs.*, users.name AS actor_name").
+		Joins("LEFT JOIN users ON users.id = audit_logs.actor_id")
+
+	if params.ActorID != uuid.Nil {
+		tx = tx.Where("audit_logs.actor_id = ?", params.ActorID)
+	}
+	if params.Action != "" {
+		// A trailing dot matches every action in the group, e.g. "tag."
+		if strings.HasSuffix(params.Action, ".") {
+			tx = tx.Where("audit_logs.action LIKE ?", params.Action+"%")
+		} else {
+			tx = tx.Where("audit_logs.action = ?", params.Action)
+		}
+	}
+	if params.TargetType != "" {
+		tx = tx.Where("audit_logs.target_type = ?", params.TargetType)
+	}
+	if params.TargetID != "" {
+		tx = tx.Where("audit_logs.target_id = ?", params.TargetID)
+	}
+	if params.IP != "" {
+		tx = tx.Where("audit_logs.ip = ?", params.IP)
+	}
+	if !params.From.IsZero() {
+		tx = tx.Where("audit_logs.created_at >= ?", params.From)
+	}
+	if !params.To.IsZero() {
+		tx = tx.Where("audit_logs.created_at < ?", params.To)
+	}
+
+	total := int64(0)
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := tx.Order("audit_logs.created_at desc").
+		Limit(params.Limit).
+		Offset(params.Offset).
+		Find(&logs).
+		Error
+
+	return logs, total, err
+}
+
+// Record appends an entry for a request, stamping the client IP. Background
+// jobs pass a nil context and are recorded without one. Failing to audit is
+// logged but never fails the request that was audited
+func Record(db *gorm.DB, log *zap.Logger, c echo.Context, entry Log) {
+	if entry.IP == "" && c != nil {
+		entry.IP = c.RealIP()
+	}
+	if err := NewModel(db).Create(entry); err != nil {
+		log.Error("Failed to write audit log", zap.String("action", entry.Action), zap.Error(err))
+	}
+}
+
+// ToJSON encodes extra detail for an entry, failing to encode is not worth
+// losing the entry over so the error is swallowed
+func ToJSON(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
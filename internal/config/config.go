@@ -14,6 +14,7 @@ import (
 	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type (
@@ -23,6 +24,10 @@ type (
 		HTTP         HTTP
 		JWT          JWT
 		AssetStorage AssetStorage
+		OIDC         OIDC
+		Password     Password
+		Lockout      Lockout
+		Trash        Trash
 	}
 
 	AppConfig struct {
@@ -42,23 +47,62 @@ type (
 	}
 
 	HTTP struct {
-		Host   string `env:"LISTEN_HOST;default:127.0.0.1"`
-		Port   int    `env:"LISTEN_PORT;default:8080"`
-		Domain string `env:"DOMAIN;default:http://localhost"`
+		Host       string `env:"LISTEN_HOST;default:127.0.0.1"`
+		Port       int    `env:"LISTEN_PORT;default:8080"`
+		Domain     string `env:"DOMAIN;default:http://localhost"`
+		TrustProxy bool   `env:"TRUST_PROXY;default:false"`
 	}
 
 	JWT struct {
-		Secret string         `env:"JWT_SECRET;required"`
-		Config echojwt.Config `env:"-"`
+		Secret         string           `env:"JWT_SECRET"`
+		SecretUntil    string           `env:"JWT_SECRET_ACCEPT_UNTIL"`
+		KeyFiles       string           `env:"JWT_KEY_FILES"`
+		PublicKeyFiles string           `env:"JWT_PUBLIC_KEY_FILES"`
+		Keys           *helpers.JWTKeys `env:"-"`
+		Config         echojwt.Config   `env:"-"`
 	}
 
 	AssetStorage struct {
+		Path              string `env:"ASSET_PATH;default:./assets"`
 		UseS3             bool   `env:"USE_S3;default:false"`
 		S3Endpoint        string `env:"S3_ENDPOINT;required_if:USE_S3=true"`
 		S3AccessKey       string `env:"S3_ACCESS_KEY;required_if:USE_S3=true"`
 		S3SecretAccessKey string `env:"S3_SECRET_ACCESS_KEY;required_if:USE_S3=true"`
 		S3UseSSL          bool   `env:"S3_USE_SSL;default:false"`
 	}
+
+	OIDC struct {
+		Enabled           bool   `env:"OIDC_ENABLED;default:false"`
+		Issuer            string `env:"OIDC_ISSUER;required_if:OIDC_ENABLED=true"`
+		ClientID          string `env:"OIDC_CLIENT_ID;required_if:OIDC_ENABLED=true"`
+		ClientSecret      string `env:"OIDC_CLIENT_SECRET"`
+		RedirectURL       string `env:"OIDC_REDIRECT_URL;required_if:OIDC_ENABLED=true"`
+		Scopes            string `env:"OIDC_SCOPES;default:openid profile email"`
+		AutoProvision     bool   `env:"OIDC_AUTO_PROVISION;default:true"`
+		LinkByEmail       bool   `env:"OIDC_LINK_BY_EMAIL;default:false"`
+		DefaultPermission int    `env:"OIDC_DEFAULT_PERMISSION;default:3"`
+	}
+
+	Password struct {
+		Algorithm     string `env:"PASSWORD_ALGORITHM;default:argon2id"`
+		BcryptCost    int    `env:"PASSWORD_BCRYPT_COST;default:12"`
+		Argon2Time    int    `env:"PASSWORD_ARGON2_TIME;default:3"`
+		Argon2Memory  int    `env:"PASSWORD_ARGON2_MEMORY;default:65536"`
+		Argon2Threads int    `env:"PASSWORD_ARGON2_THREADS;default:2"`
+	}
+
+	Lockout struct {
+		MaxAttempts   int           `env:"LOGIN_MAX_ATTEMPTS;default:5"`
+		MaxAttemptsIP int           `env:"LOGIN_MAX_ATTEMPTS_IP;default:20"`
+		BaseDuration  time.Duration `env:"LOGIN_LOCKOUT_BASE;default:1m"`
+		MaxDuration   time.Duration `env:"LOGIN_LOCKOUT_MAX;default:24h"`
+		Window        time.Duration `env:"LOGIN_ATTEMPT_WINDOW;default:1h"`
+	}
+
+	Trash struct {
+		Retention     time.Duration `env:"TRASH_RETENTION;default:720h"`
+		PurgeInterval time.Duration `env:"TRASH_PURGE_INTERVAL;default:1h"`
+	}
 )
 
 var log *zap.Logger
@@ -80,16 +124,83 @@ func LoadConfig() (*Config, error) {
 		return nil, err
 	}
 
+	if err := c.Password.Validate(); err != nil {
+		log.Error("Invalid password hashing config", zap.Error(err))
+		return nil, err
+	}
+
+	secretUntil := time.Time{}
+	if c.JWT.SecretUntil != "" {
+		if secretUntil, err = time.Parse(time.RFC3339, c.JWT.SecretUntil); err != nil {
+			log.Error("Invalid JWT_SECRET_ACCEPT_UNTIL, expected an RFC 3339 time", zap.Error(err))
+			return nil, err
+		}
+	}
+
+	c.JWT.Keys, err = helpers.LoadJWTKeys(splitList(c.JWT.KeyFiles), splitList(c.JWT.PublicKeyFiles), c.JWT.Secret, secretUntil)
+	if err != nil {
+		log.Error("Failed to load JWT keys", zap.Error(err))
+		return nil, err
+	}
+
 	c.JWT.Config = echojwt.Config{
 		NewClaimsFunc: func(c echo.Context) jwt.Claims {
 			return new(helpers.JWTUser)
 		},
-		SigningKey: []byte(c.JWT.Secret),
+		KeyFunc: c.JWT.Keys.Keyfunc,
 	}
 
 	return c, nil
 }
 
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Hasher builds the password hasher, zero values keep the defaults so a
+// partially filled config still produces usable hashes
+func (p Password) Hasher() helpers.PasswordHasher {
+	hasher := helpers.DefaultPasswordHasher
+	if p.Algorithm != "" {
+		hasher.Algorithm = p.Algorithm
+	}
+	if p.BcryptCost != 0 {
+		hasher.BcryptCost = p.BcryptCost
+	}
+	if p.Argon2Time != 0 {
+		hasher.Argon2Time = uint32(p.Argon2Time)
+	}
+	if p.Argon2Memory != 0 {
+		hasher.Argon2Memory = uint32(p.Argon2Memory)
+	}
+	if p.Argon2Threads != 0 {
+		hasher.Argon2Threads = uint8(p.Argon2Threads)
+	}
+	return hasher
+}
+
+func (p Password) Validate() error {
+	switch p.Algorithm {
+	case helpers.AlgorithmArgon2id:
+		if p.Argon2Time < 1 || p.Argon2Memory < 8*p.Argon2Threads || p.Argon2Threads < 1 || p.Argon2Threads > 255 {
+			return fmt.Errorf("invalid argon2id parameters t=%d m=%d p=%d", p.Argon2Time, p.Argon2Memory, p.Argon2Threads)
+		}
+	case helpers.AlgorithmBcrypt:
+		if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return fmt.Errorf("unknown password algorithm %s", p.Algorithm)
+	}
+	return nil
+}
+
 func ProcessStruct(val reflect.Value, parentField string) error {
 	missingRequired := []string{}
 
@@ -112,7 +223,7 @@ func ProcessStruct(val reflect.Value, parentField string) error {
 		}
 
 		tagValue := fieldType.Tag.Get("env")
-		if tagValue == "" {
+		if tagValue == "" || tagValue == "-" {
 			continue
 		}
 
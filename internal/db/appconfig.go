@@ -15,4 +15,10 @@ func FetchSettings(cfg *config.Config, db *gorm.DB) {
 	}
 
 	cfg.AppConfig.AdminCreated = adminSettings.ValueBool
+
+	registrationSettings := setting.AppSetting{}
+	if err := db.Where("key = ?", setting.KeyRegistrationMode).First(&registrationSettings).Error; err != nil {
+		registrationSettings := setting.AppSetting{Key: setting.KeyRegistrationMode, ValueString: setting.RegistrationOpen}
+		db.Create(&registrationSettings)
+	}
 }
@@ -3,8 +3,12 @@ package db
 import (
 	"fmt"
 	"maribooru/internal/account"
+	"maribooru/internal/audit"
 	"maribooru/internal/config"
+	"maribooru/internal/notification"
+	"maribooru/internal/oidc"
 	"maribooru/internal/permission"
+	"maribooru/internal/report"
 	"maribooru/internal/setting"
 	"maribooru/internal/tag"
 
@@ -33,13 +37,32 @@ func InitDatabase(cfg *config.Config, log *zap.Logger) (*gorm.DB, error) {
 	db.AutoMigrate(
 		account.User{},
 		account.Admin{},
+		account.LoginAttempt{},
+		account.Invite{},
+		account.Ban{},
+		audit.Log{},
 		setting.AppSetting{},
 		permission.Permission{},
+		permission.Role{},
+		permission.RoleCapability{},
+		permission.UserRole{},
+		permission.TrashedUserRole{},
 		tag.TagCategory{},
 		tag.Tag{},
+		tag.Artist{},
+		tag.ArtistName{},
+		tag.ArtistURL{},
+		oidc.Identity{},
+		oidc.AuthRequest{},
+		report.Report{},
+		notification.Notification{},
 	)
 
 	FetchSettings(cfg, db)
 
+	if err := MigrateRoles(db, log); err != nil {
+		log.Fatal("Failed to migrate roles:", zap.Error(err))
+	}
+
 	return db, err
 }
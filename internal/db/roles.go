@@ -0,0 +1,50 @@
+package db
+
+import (
+	"maribooru/internal/account"
+	"maribooru/internal/permission"
+	"maribooru/internal/setting"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// MigrateRoles seeds the built in roles and, once, gives every existing user
+// the roles matching their permission bits and admin position
+func MigrateRoles(db *gorm.DB, log *zap.Logger) error {
+	if err := permission.NewRoleModel(db).SeedBuiltIn(); err != nil {
+		return err
+	}
+
+	migrated := setting.AppSetting{}
+	if err := db.Where("key = ?", setting.KeyRolesMigrated).First(&migrated).Error; err == nil && migrated.ValueBool {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		model := permission.NewRoleModel(tx)
+
+		permissions := []permission.Permission{}
+		if err := tx.Find(&permissions).Error; err != nil {
+			return err
+		}
+		for _, p := range permissions {
+			if err := model.AssignRoles(p.UserID, p.Permission.Roles()...); err != nil {
+				return err
+			}
+		}
+
+		admins := []account.Admin{}
+		if err := tx.Find(&admins).Error; err != nil {
+			return err
+		}
+		for _, admin := range admins {
+			if err := model.AssignRoles(admin.UserID, permission.RoleAdmin); err != nil {
+				return err
+			}
+		}
+
+		log.Info("Migrated permissions to roles", zap.Int("permissions", len(permissions)), zap.Int("admins", len(admins)))
+		return tx.Create(&setting.AppSetting{Key: setting.KeyRolesMigrated, ValueBool: true}).Error
+	})
+}
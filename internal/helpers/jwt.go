@@ -1,7 +1,16 @@
 package helpers
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"os"
+	"sort"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -9,14 +18,236 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
-type JWTUser struct {
-	Name            string      `json:"name"`
-	ID              uuid.UUID   `json:"id"`
-	UpdatedSecurity interface{} `json:"updated_security"`
-	jwt.RegisteredClaims
+type (
+	JWTUser struct {
+		Name            string      `json:"name"`
+		ID              uuid.UUID   `json:"id"`
+		UpdatedSecurity interface{} `json:"updated_security"`
+		jwt.RegisteredClaims
+	}
+
+	JWTKey struct {
+		ID      string
+		Method  jwt.SigningMethod
+		Private crypto.Signer
+		Public  crypto.PublicKey
+	}
+
+	// JWTKeys signs with the first private key and verifies with any of them,
+	// so rotating means putting the new key first and keeping the old one
+	// around until its tokens expire
+	JWTKeys struct {
+		signing     *JWTKey
+		keys        map[string]*JWTKey
+		secret      []byte
+		secretUntil time.Time
+	}
+)
+
+var ErrUnknownSigningKey = errors.New("Unknown signing key")
+
+func NewHMACKeys(secret string) *JWTKeys {
+	return &JWTKeys{
+		keys:   map[string]*JWTKey{},
+		secret: []byte(secret),
+	}
+}
+
+// LoadJWTKeys reads PEM encoded keys. Private keys can sign, the first one is
+// used for new tokens. Public keys only verify tokens from retired keys. Once
+// a private key is loaded the secret only verifies HS256 tokens until
+// secretUntil, a zero time refuses them straight away.
+func LoadJWTKeys(privateFiles, publicFiles []string, secret string, secretUntil time.Time) (*JWTKeys, error) {
+	keys := NewHMACKeys(secret)
+	keys.secretUntil = secretUntil
+
+	for _, file := range privateFiles {
+		key, err := loadJWTKey(file, true)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if keys.signing == nil {
+			keys.signing = key
+		}
+		keys.keys[key.ID] = key
+	}
+
+	for _, file := range publicFiles {
+		key, err := loadJWTKey(file, false)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if _, exists := keys.keys[key.ID]; !exists {
+			keys.keys[key.ID] = key
+		}
+	}
+
+	if keys.signing == nil && len(keys.secret) == 0 {
+		return nil, errors.New("No JWT signing key or secret configured")
+	}
+
+	return keys, nil
 }
 
-func GenerateJWT(uid uuid.UUID, name string, secret string, expiry time.Duration) (string, error) {
+func loadJWTKey(file string, private bool) (*JWTKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("No PEM data found")
+	}
+
+	key := &JWTKey{}
+	if private {
+		var parsed interface{}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			parsed, err = x509.ParseECPrivateKey(block.Bytes)
+		default:
+			parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("Unsupported private key type")
+		}
+		key.Private = signer
+		key.Public = signer.Public()
+	} else {
+		switch block.Type {
+		case "RSA PUBLIC KEY":
+			key.Public, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		default:
+			key.Public, err = x509.ParsePKIXPublicKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch pub := key.Public.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		switch pub.Curve.Params().BitSize {
+		case 256:
+			key.Method = jwt.SigningMethodES256
+		case 384:
+			key.Method = jwt.SigningMethodES384
+		case 521:
+			key.Method = jwt.SigningMethodES512
+		default:
+			return nil, errors.New("Unsupported curve")
+		}
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, errors.New("Unsupported key type")
+	}
+
+	jwk, err := NewJWK(key.Public)
+	if err != nil {
+		return nil, err
+	}
+	if key.ID, err = jwk.Thumbprint(); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (k *JWTKeys) Sign(claims jwt.Claims) (string, error) {
+	if k.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.secret)
+	}
+
+	token := jwt.NewWithClaims(k.signing.Method, claims)
+	token.Header["kid"] = k.signing.ID
+	return token.SignedString(k.signing.Private)
+}
+
+// Keyfunc picks the verification key by the kid header, and refuses tokens
+// whose algorithm doesn't belong to that key
+func (k *JWTKeys) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+		if !k.acceptsSecret() {
+			return nil, ErrUnknownSigningKey
+		}
+		return k.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, found := k.keys[kid]
+	if !found || key.Method.Alg() != token.Method.Alg() {
+		return nil, ErrUnknownSigningKey
+	}
+	return key.Public, nil
+}
+
+func (k *JWTKeys) Methods() []string {
+	methods := []string{}
+	if k.acceptsSecret() {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	seen := map[string]bool{}
+	for _, key := range k.keys {
+		if !seen[key.Method.Alg()] {
+			seen[key.Method.Alg()] = true
+			methods = append(methods, key.Method.Alg())
+		}
+	}
+	return methods
+}
+
+// acceptsSecret reports whether HS256 tokens are still valid. The secret
+// stops counting once a private key signs, apart from the migration window.
+func (k *JWTKeys) acceptsSecret() bool {
+	if len(k.secret) == 0 {
+		return false
+	}
+	return k.signing == nil || time.Now().Before(k.secretUntil)
+}
+
+// JWKS lists the public keys, the HMAC secret is never published
+func (k *JWTKeys) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+
+	// Keep the signing key first, consumers tend to try keys in order
+	ordered := []*JWTKey{}
+	for _, key := range k.keys {
+		if key != k.signing {
+			ordered = append(ordered, key)
+		}
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].ID < ordered[j].ID
+	})
+	if k.signing != nil {
+		ordered = append([]*JWTKey{k.signing}, ordered...)
+	}
+
+	for _, key := range ordered {
+		jwk, err := NewJWK(key.Public)
+		if err != nil {
+			continue
+		}
+		jwk.Kid = key.ID
+		jwk.Alg = key.Method.Alg()
+		jwk.Use = "sig"
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func GenerateJWT(uid uuid.UUID, name string, keys *JWTKeys, expiry time.Duration) (string, error) {
 	claims := JWTUser{
 		Name: name,
 		ID:   uid,
@@ -25,21 +256,17 @@ func GenerateJWT(uid uuid.UUID, name string, secret string, expiry time.Duration
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	return keys.Sign(claims)
 }
 
-func GetUserID(c echo.Context, secret string) (uuid.UUID, error) {
-	secretByte := []byte(secret)
+func GetUserID(c echo.Context, keys *JWTKeys) (uuid.UUID, error) {
 	token := c.Request().Header.Get("Authorization")
-	if token == "" {
+	if len(token) < 8 {
 		return uuid.Nil, errors.New("Token is empty")
 	}
 
 	claims := JWTUser{}
-	_, err := jwt.ParseWithClaims(token[7:], &claims, func(token *jwt.Token) (interface{}, error) {
-		return secretByte, nil
-	})
+	_, err := jwt.ParseWithClaims(token[7:], &claims, keys.Keyfunc, jwt.WithValidMethods(keys.Methods()))
 	if err != nil {
 		return uuid.Nil, err
 	}
@@ -0,0 +1,180 @@
+package helpers_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"maribooru/internal/helpers"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+func writeKey(t *testing.T, dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaDER, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaFile := writeKey(t, dir, "rsa.pem", "PRIVATE KEY", rsaDER)
+	rsaPublicFile := writeKey(t, dir, "rsa.pub", "PUBLIC KEY", rsaPublicDER)
+	edFile := writeKey(t, dir, "ed.pem", "PRIVATE KEY", edDER)
+
+	oldKeys, err := helpers.LoadJWTKeys([]string{rsaFile}, nil, "", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := uuid.New()
+	oldToken, err := helpers.GenerateJWT(id, "rotation", oldKeys, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate to Ed25519, the RSA key is retired to verify only
+	newKeys, err := helpers.LoadJWTKeys([]string{edFile}, []string{rsaPublicFile}, "", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newToken, err := helpers.GenerateJWT(id, "rotation", newKeys, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &helpers.JWTUser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Method.Alg() != "EdDSA" || parsed.Header["kid"] == "" {
+		t.Errorf("new token has alg %v and kid %v, want EdDSA with a kid", parsed.Method.Alg(), parsed.Header["kid"])
+	}
+
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		e := echo.New()
+		req := httptest.NewRequest(echo.GET, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		got, err := helpers.GetUserID(e.NewContext(req, httptest.NewRecorder()), newKeys)
+		if err != nil || got != id {
+			t.Errorf("GetUserID() with %s token = %v, %v, want %v", name, got, err, id)
+		}
+	}
+
+	// A symmetric token can't be forged once only asymmetric keys are set
+	forged, err := helpers.GenerateJWT(id, "rotation", helpers.NewHMACKeys("guess"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(echo.GET, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+forged)
+	if _, err := helpers.GetUserID(echo.New().NewContext(req, httptest.NewRecorder()), newKeys); err == nil {
+		t.Errorf("GetUserID() accepted an HS256 token without a configured secret")
+	}
+
+	jwks := newKeys.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("JWKS() has %d keys, want 2", len(jwks.Keys))
+	}
+	if jwks.Keys[0].Kid != parsed.Header["kid"] {
+		t.Errorf("JWKS() lists %s first, want the signing key %v", jwks.Keys[0].Kid, parsed.Header["kid"])
+	}
+	for _, key := range jwks.Keys {
+		if _, err := key.PublicKey(); err != nil {
+			t.Errorf("JWKS() key %s does not decode: %v", key.Kid, err)
+		}
+	}
+}
+
+func TestJWTSecretCutoff(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edFile := writeKey(t, t.TempDir(), "ed.pem", "PRIVATE KEY", edDER)
+
+	id := uuid.New()
+	legacy, err := helpers.GenerateJWT(id, "cutoff", helpers.NewHMACKeys("secret"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		secretUntil time.Time
+		wantErr     bool
+	}{
+		{
+			name:    "refused without a window",
+			wantErr: true,
+		},
+		{
+			name:        "accepted inside the window",
+			secretUntil: time.Now().Add(time.Hour),
+		},
+		{
+			name:        "refused after the window",
+			secretUntil: time.Now().Add(-time.Hour),
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := helpers.LoadJWTKeys([]string{edFile}, nil, "secret", tt.secretUntil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, method := range keys.Methods() {
+				if method == "HS256" && tt.wantErr {
+					t.Errorf("Methods() = %v, want no HS256", keys.Methods())
+				}
+			}
+
+			req := httptest.NewRequest(echo.GET, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+legacy)
+			_, err = helpers.GetUserID(echo.New().NewContext(req, httptest.NewRecorder()), keys)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetUserID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,14 +1,164 @@
 package helpers
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type PasswordHasher struct {
+	Algorithm     string
+	BcryptCost    int
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+	Argon2KeyLen  uint32
+	Argon2SaltLen uint32
+}
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+var (
+	ErrPasswordMismatch     = errors.New("Password does not match")
+	ErrUnknownHashAlgorithm = errors.New("Unknown password hash algorithm")
+	ErrInvalidHash          = errors.New("Invalid password hash")
+)
+
+var DefaultPasswordHasher = PasswordHasher{
+	Algorithm:     AlgorithmArgon2id,
+	BcryptCost:    12,
+	Argon2Time:    3,
+	Argon2Memory:  64 * 1024,
+	Argon2Threads: 2,
+	Argon2KeyLen:  32,
+	Argon2SaltLen: 16,
+}
+
+// PasswordHash hashes with the default parameters, prefer the hasher from
+// config.Password where the config is available
 func PasswordHash(passwd string) (string, error) {
-	passwordBytes := []byte(passwd)
-	hashedPasswordBytes, err := bcrypt.
-		GenerateFromPassword(passwordBytes, bcrypt.MinCost)
+	return DefaultPasswordHasher.Hash(passwd)
+}
 
-	if err := bcrypt.CompareHashAndPassword(hashedPasswordBytes, passwordBytes); err != nil {
-		return "", err
+func (h PasswordHasher) Hash(passwd string) (string, error) {
+	switch h.Algorithm {
+	case AlgorithmArgon2id:
+		salt := make([]byte, h.Argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(passwd), salt, h.Argon2Time, h.Argon2Memory, h.Argon2Threads, h.Argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version,
+			h.Argon2Memory,
+			h.Argon2Time,
+			h.Argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		), nil
+	case AlgorithmBcrypt:
+		hashed, err := bcrypt.GenerateFromPassword([]byte(passwd), h.BcryptCost)
+		return string(hashed), err
 	}
-	return string(hashedPasswordBytes), err
+	return "", ErrUnknownHashAlgorithm
+}
+
+// NeedsRehash reports whether the stored hash was made with a different
+// algorithm or weaker parameters than the hasher is configured for
+func (h PasswordHasher) NeedsRehash(hash string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		if h.Algorithm != AlgorithmArgon2id {
+			return true
+		}
+		params, _, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return true
+		}
+		return params.Argon2Time != h.Argon2Time ||
+			params.Argon2Memory != h.Argon2Memory ||
+			params.Argon2Threads != h.Argon2Threads ||
+			uint32(len(key)) != h.Argon2KeyLen
+	case isBcrypt(hash):
+		if h.Algorithm != AlgorithmBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != h.BcryptCost
+	}
+	return true
+}
+
+// PasswordVerify checks the password against a hash made by any supported
+// algorithm, the parameters are read from the hash itself
+func PasswordVerify(hash, passwd string) error {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return err
+		}
+		other := argon2.IDKey([]byte(passwd), salt, params.Argon2Time, params.Argon2Memory, params.Argon2Threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return ErrPasswordMismatch
+		}
+		return nil
+	case isBcrypt(hash):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(passwd))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrPasswordMismatch
+		}
+		return err
+	}
+	return ErrUnknownHashAlgorithm
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func decodeArgon2id(hash string) (PasswordHasher, []byte, []byte, error) {
+	params := PasswordHasher{Algorithm: AlgorithmArgon2id}
+
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	if version != argon2.Version {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Argon2Memory, &params.Argon2Time, &params.Argon2Threads); err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	params.Argon2SaltLen = uint32(len(salt))
+	params.Argon2KeyLen = uint32(len(key))
+
+	return params, salt, key, nil
 }
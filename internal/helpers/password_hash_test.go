@@ -0,0 +1,81 @@
+package helpers_test
+
+import (
+	"maribooru/internal/helpers"
+	"testing"
+)
+
+func TestPasswordHasher(t *testing.T) {
+	argon := helpers.DefaultPasswordHasher
+	argon.Argon2Memory = 1024
+	argon.Argon2Time = 1
+
+	bcrypt := helpers.DefaultPasswordHasher
+	bcrypt.Algorithm = helpers.AlgorithmBcrypt
+	bcrypt.BcryptCost = 4
+
+	tests := []struct {
+		name   string
+		hasher helpers.PasswordHasher
+	}{
+		{
+			name:   "argon2id",
+			hasher: argon,
+		},
+		{
+			name:   "bcrypt",
+			hasher: bcrypt,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := tt.hasher.Hash("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := helpers.PasswordVerify(hash, "correct horse"); err != nil {
+				t.Errorf("helpers.PasswordVerify() = %v, want nil", err)
+			}
+			if err := helpers.PasswordVerify(hash, "battery staple"); err != helpers.ErrPasswordMismatch {
+				t.Errorf("helpers.PasswordVerify() = %v, want %v", err, helpers.ErrPasswordMismatch)
+			}
+			if tt.hasher.NeedsRehash(hash) {
+				t.Errorf("NeedsRehash() = true for a hash made with the same parameters")
+			}
+		})
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	weak := helpers.DefaultPasswordHasher
+	weak.Algorithm = helpers.AlgorithmBcrypt
+	weak.BcryptCost = 4
+
+	hash, err := weak.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stronger := weak
+	stronger.BcryptCost = 5
+	if !stronger.NeedsRehash(hash) {
+		t.Errorf("NeedsRehash() = false after raising the bcrypt cost")
+	}
+
+	argon := helpers.DefaultPasswordHasher
+	if !argon.NeedsRehash(hash) {
+		t.Errorf("NeedsRehash() = false for a bcrypt hash when argon2id is configured")
+	}
+
+	argon.Argon2Memory = 1024
+	argon.Argon2Time = 1
+	hash, err = argon.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	argon.Argon2Time = 2
+	if !argon.NeedsRehash(hash) {
+		t.Errorf("NeedsRehash() = false after raising the argon2id time cost")
+	}
+}
@@ -0,0 +1,15 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// RandomString returns length random bytes encoded as URL safe base64
+func RandomString(length int) (string, error) {
+	buf := make([]byte, length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
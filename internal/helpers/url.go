@@ -0,0 +1,138 @@
+package helpers
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+)
+
+var (
+	// Mirrors and mobile hosts that serve the same pages as the canonical host
+	canonicalHosts = map[string]string{
+		"x.com":           "twitter.com",
+		"fxtwitter.com":   "twitter.com",
+		"vxtwitter.com":   "twitter.com",
+		"fixupx.com":      "twitter.com",
+		"sp.nicovideo.jp": "nicovideo.jp",
+	}
+
+	hostPrefixes = []string{"www.", "m.", "mobile."}
+
+	// Second level labels that are registered under a country code, as in
+	// "example.co.jp"
+	secondLevelLabels = map[string]bool{
+		"ac":  true,
+		"co":  true,
+		"com": true,
+		"ne":  true,
+		"net": true,
+		"or":  true,
+		"org": true,
+	}
+
+	// Click ids added by whoever linked to the page, on any host
+	trackingParams = map[string]bool{
+		"fbclid": true,
+		"gclid":  true,
+	}
+
+	// Parameters that only mean tracking on a given host, elsewhere they can
+	// pick the page
+	hostTrackingParams = map[string]map[string]bool{
+		"twitter.com":   {"s": true, "t": true, "ref_src": true},
+		"instagram.com": {"igshid": true, "igsh": true},
+		"youtube.com":   {"si": true, "feature": true},
+		"youtu.be":      {"si": true, "feature": true},
+	}
+
+	ErrInvalidURL = errors.New("Invalid URL")
+)
+
+// NormalizeURL reduces a URL to a comparable form without scheme, tracking
+// parameters, fragment or trailing slash, e.g. "https://www.x.com/User/?s=20"
+// becomes "twitter.com/User". Only the host is lowercased, paths are case
+// sensitive on plenty of sites.
+func NormalizeURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Hostname() == "" {
+		return "", ErrInvalidURL
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", ErrInvalidURL
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	for _, prefix := range hostPrefixes {
+		host = strings.TrimPrefix(host, prefix)
+	}
+	if canonical, ok := canonicalHosts[host]; ok {
+		host = canonical
+	}
+
+	path := strings.TrimRight(parsed.EscapedPath(), "/")
+
+	query := parsed.Query()
+	for key := range query {
+		lower := strings.ToLower(key)
+		if trackingParams[lower] || hostTrackingParams[host][lower] || strings.HasPrefix(lower, "utm_") {
+			query.Del(key)
+		}
+	}
+
+	normalized := host + path
+	if len(query) > 0 {
+		normalized += "?" + query.Encode()
+	}
+	return normalized, nil
+}
+
+// URLPrefixes lists a normalized URL followed by its parent paths, longest
+// first. The bare host is only included when it is a subdomain, like
+// "artist.fanbox.cc", since a registrable domain on its own names a whole
+// site rather than a profile.
+func URLPrefixes(normalized string) []string {
+	prefixes := []string{normalized}
+
+	path, _, _ := strings.Cut(normalized, "?")
+	if path != normalized {
+		prefixes = append(prefixes, path)
+	}
+
+	for {
+		index := strings.LastIndex(path, "/")
+		if index < 0 {
+			break
+		}
+		path = path[:index]
+		if !strings.Contains(path, "/") {
+			break
+		}
+		prefixes = append(prefixes, path)
+	}
+
+	host, _, _ := strings.Cut(path, "/")
+	if isSubdomain(host) && prefixes[len(prefixes)-1] != host {
+		prefixes = append(prefixes, host)
+	}
+	return prefixes
+}
+
+// isSubdomain reports whether the host has labels in front of its
+// registrable domain
+func isSubdomain(host string) bool {
+	if net.ParseIP(host) != nil {
+		return false
+	}
+	labels := strings.Split(host, ".")
+	registrable := 2
+	if len(labels) > 2 && len(labels[len(labels)-1]) == 2 && secondLevelLabels[labels[len(labels)-2]] {
+		registrable = 3
+	}
+	return len(labels) > registrable
+}
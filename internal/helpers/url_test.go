@@ -0,0 +1,132 @@
+package helpers_test
+
+import (
+	"maribooru/internal/helpers"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "canonical host",
+			raw:  "https://WWW.X.com/Artist/",
+			want: "twitter.com/Artist",
+		},
+		{
+			name: "tracking params",
+			raw:  "https://twitter.com/artist/status/1?s=20&t=abc&utm_source=share",
+			want: "twitter.com/artist/status/1",
+		},
+		{
+			name: "identifying params are kept",
+			raw:  "http://www.pixiv.net/member.php?id=123&utm_medium=share#top",
+			want: "pixiv.net/member.php?id=123",
+		},
+		{
+			name: "tracking params of other hosts are kept",
+			raw:  "https://example.com/gallery?s=2&t=abc&ref=home&fbclid=x",
+			want: "example.com/gallery?ref=home&s=2&t=abc",
+		},
+		{
+			name: "missing scheme",
+			raw:  "  artist.example.com  ",
+			want: "artist.example.com",
+		},
+		{
+			name:    "unsupported scheme",
+			raw:     "ftp://example.com/file",
+			wantErr: true,
+		},
+		{
+			name:    "no host",
+			raw:     "https://",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := helpers.NormalizeURL(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NormalizeURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeURLPathCase(t *testing.T) {
+	upper, err := helpers.NormalizeURL("https://www.youtube.com/channel/UCabcDEF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lower, err := helpers.NormalizeURL("https://www.youtube.com/channel/UCABCdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upper == lower {
+		t.Errorf("NormalizeURL() merged %q and its differently cased path", upper)
+	}
+}
+
+func TestURLPrefixes(t *testing.T) {
+	got := helpers.URLPrefixes("twitter.com/artist/status/1?lang=en")
+	want := []string{
+		"twitter.com/artist/status/1?lang=en",
+		"twitter.com/artist/status/1",
+		"twitter.com/artist/status",
+		"twitter.com/artist",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("URLPrefixes() = %v, want %v", got, want)
+	}
+}
+
+func TestURLPrefixesHost(t *testing.T) {
+	tests := []struct {
+		name       string
+		normalized string
+		want       []string
+	}{
+		{
+			name:       "subdomain profile",
+			normalized: "artist.fanbox.cc/posts/1",
+			want:       []string{"artist.fanbox.cc/posts/1", "artist.fanbox.cc/posts", "artist.fanbox.cc"},
+		},
+		{
+			name:       "bare subdomain",
+			normalized: "artist.tumblr.com",
+			want:       []string{"artist.tumblr.com"},
+		},
+		{
+			name:       "registrable domain",
+			normalized: "twitter.com/artist",
+			want:       []string{"twitter.com/artist"},
+		},
+		{
+			name:       "country code second level",
+			normalized: "example.co.jp/artist",
+			want:       []string{"example.co.jp/artist"},
+		},
+		{
+			name:       "subdomain under country code second level",
+			normalized: "artist.example.co.jp/gallery",
+			want:       []string{"artist.example.co.jp/gallery", "artist.example.co.jp"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := helpers.URLPrefixes(tt.normalized)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("URLPrefixes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
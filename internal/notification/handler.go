@@ -0,0 +1,132 @@
+package notification
+
+import (
+	"errors"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type (
+	Params struct {
+		helpers.GenericPagedQuery
+		Unread bool `query:"unread"`
+	}
+
+	Response struct {
+		ID         uuid.UUID `json:"id"`
+		Kind       string    `json:"kind"`
+		Message    string    `json:"message"`
+		TargetType string    `json:"target_type"`
+		TargetID   string    `json:"target_id"`
+		Read       bool      `json:"read"`
+		CreatedAt  time.Time `json:"created_at"`
+	}
+
+	Handler struct {
+		db    *gorm.DB
+		model *Model
+		cfg   *config.Config
+		log   *zap.Logger
+	}
+)
+
+func (n *Notification) ToResponse() Response {
+	return Response{
+		ID:         n.ID,
+		Kind:       n.Kind,
+		Message:    n.Message,
+		TargetType: n.TargetType,
+		TargetID:   n.TargetID,
+		Read:       !n.ReadAt.IsZero(),
+		CreatedAt:  n.CreatedAt,
+	}
+}
+
+func (n NotificationSlice) ToResponse() []Response {
+	response := make([]Response, 0)
+	for _, notification := range n {
+		response = append(response, notification.ToResponse())
+	}
+	return response
+}
+
+func NewHandler(db *gorm.DB, cfg *config.Config, log *zap.Logger) *Handler {
+	return &Handler{
+		db:    db,
+		model: NewModel(db),
+		cfg:   cfg,
+		log:   log,
+	}
+}
+
+func (n *Handler) GetAll(c echo.Context) error {
+	n.log.Debug("NotificationHandler: GetAll")
+	userID, err := helpers.GetUserID(c, n.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	params := Params{
+		GenericPagedQuery: helpers.GenericPagedQuery{
+			Limit:  50,
+			Offset: 0,
+		},
+	}
+	if err := c.Bind(&params); err != nil {
+		n.log.Error("Failed to set limit and offset, defaulting to 50 limit and 0 offset", zap.Error(err))
+	}
+
+	data, total, err := n.model.GetAll(userID, params)
+	if err != nil {
+		n.log.Error("Failed to get notifications", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get notifications")
+	}
+
+	paged := helpers.PageData(data.ToResponse(), int(total), params.Offset, params.Limit)
+
+	return helpers.Response(c, http.StatusOK, paged, "")
+}
+
+func (n *Handler) MarkRead(c echo.Context) error {
+	n.log.Debug("NotificationHandler: MarkRead")
+	userID, err := helpers.GetUserID(c, n.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
+	}
+
+	return n.markRead(c, userID, id)
+}
+
+func (n *Handler) MarkAllRead(c echo.Context) error {
+	n.log.Debug("NotificationHandler: MarkAllRead")
+	userID, err := helpers.GetUserID(c, n.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	return n.markRead(c, userID, uuid.Nil)
+}
+
+func (n *Handler) markRead(c echo.Context, userID, id uuid.UUID) error {
+	if err := n.model.MarkRead(userID, id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Notification not found")
+		}
+		n.log.Error("Failed to mark notification read", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to mark notification read")
+	}
+
+	return helpers.Response(c, http.StatusOK, nil, "")
+}
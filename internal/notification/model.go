@@ -0,0 +1,92 @@
+package notification
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type (
+	Notification struct {
+		ID         uuid.UUID `gorm:"primary_key;type:uuid"`
+		UserID     uuid.UUID `gorm:"type:uuid;not null;index"`
+		Kind       string    `gorm:"not null"`
+		Message    string    `gorm:"not null"`
+		TargetType string
+		TargetID   string
+		ReadAt     time.Time `gorm:"default:null"`
+		CreatedAt  time.Time
+	}
+
+	NotificationSlice []Notification
+
+	Model struct {
+		db *gorm.DB
+	}
+)
+
+const (
+	KindReportResolved  = "report.resolved"
+	KindReportDismissed = "report.dismissed"
+)
+
+func (n *Notification) BeforeCreate(tx *gorm.DB) error {
+	n.ID = uuid.New()
+	return nil
+}
+
+func NewModel(db *gorm.DB) *Model {
+	return &Model{
+		db: db,
+	}
+}
+
+func (m *Model) Create(notifications ...Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+	return m.db.Create(&notifications).Error
+}
+
+func (m *Model) GetAll(userID uuid.UUID, params Params) (NotificationSlice, int64, error) {
+	notifications := NotificationSlice{}
+	tx := m.db.Model(&Notification{}).Where("user_id = ?", userID)
+
+	if params.Unread {
+		tx = tx.Where("read_at IS NULL")
+	}
+
+	total := int64(0)
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := tx.Order("created_at desc").
+		Limit(params.Limit).
+		Offset(params.Offset).
+		Find(&notifications).
+		Error
+
+	return notifications, total, err
+}
+
+// MarkRead marks the user's notification as read, or all of them when id is
+// nil
+func (m *Model) MarkRead(userID, id uuid.UUID) error {
+	tx := m.db.Model(&Notification{}).
+		Where("user_id = ?", userID).
+		Where("read_at IS NULL")
+	if id != uuid.Nil {
+		tx = tx.Where("id = ?", id)
+	}
+
+	res := tx.Update("read_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if id != uuid.Nil && res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,447 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maribooru/internal/account"
+	"maribooru/internal/audit"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"maribooru/internal/permission"
+	"maribooru/internal/setting"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type (
+	LoginResponse struct {
+		URL string `json:"url"`
+	}
+
+	IdentityResponse struct {
+		ID        uuid.UUID `json:"id"`
+		Issuer    string    `json:"issuer"`
+		Subject   string    `json:"subject"`
+		Email     string    `json:"email,omitempty"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	Handler struct {
+		db       *gorm.DB
+		model    *Model
+		provider *Provider
+		cfg      *config.Config
+		log      *zap.Logger
+	}
+)
+
+const (
+	authRequestLifetime = 10 * time.Minute
+
+	// bindingCookie ties an auth request to the browser that started it, so
+	// a callback can't be finished by somebody else
+	bindingCookie = "oidc_binding"
+)
+
+func (i *Identity) ToResponse() IdentityResponse {
+	return IdentityResponse{
+		ID:        i.ID,
+		Issuer:    i.Issuer,
+		Subject:   i.Subject,
+		Email:     i.Email,
+		CreatedAt: i.CreatedAt,
+	}
+}
+
+func (i IdentitySlice) ToResponse() []IdentityResponse {
+	response := make([]IdentityResponse, 0)
+	for _, identity := range i {
+		response = append(response, identity.ToResponse())
+	}
+	return response
+}
+
+func NewHandler(db *gorm.DB, cfg *config.Config, log *zap.Logger) *Handler {
+	return &Handler{
+		db:       db,
+		model:    NewModel(db),
+		provider: NewProvider(cfg.OIDC, nil),
+		cfg:      cfg,
+		log:      log,
+	}
+}
+
+// Login starts the authorization code flow. When called with a valid token the
+// resulting identity is linked to the current user instead of signing in.
+func (o *Handler) Login(c echo.Context) error {
+	o.log.Debug("OIDCHandler: Login")
+
+	request := AuthRequest{
+		ExpiresAt: time.Now().Add(authRequestLifetime),
+	}
+
+	if c.Request().Header.Get("Authorization") != "" {
+		userID, err := helpers.GetUserID(c, o.cfg.JWT.Keys)
+		if err != nil {
+			return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+		}
+		request.LinkUserID = userID
+	}
+
+	var err error
+	if request.State, err = helpers.RandomString(32); err != nil {
+		o.log.Error("Failed to generate state", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to start sign in")
+	}
+	if request.Nonce, err = helpers.RandomString(32); err != nil {
+		o.log.Error("Failed to generate nonce", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to start sign in")
+	}
+	if request.CodeVerifier, err = helpers.RandomString(48); err != nil {
+		o.log.Error("Failed to generate code verifier", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to start sign in")
+	}
+	binding, err := helpers.RandomString(32)
+	if err != nil {
+		o.log.Error("Failed to generate state binding", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to start sign in")
+	}
+	request.Binding = hashBinding(binding)
+
+	authURL, err := o.provider.AuthCodeURL(request.State, request.Nonce, request.CodeVerifier)
+	if err != nil {
+		o.log.Error("Failed to discover identity provider", zap.Error(err))
+		return helpers.Response(c, http.StatusBadGateway, nil, "Identity provider is unavailable")
+	}
+
+	if err := o.model.PurgeExpiredAuthRequests(); err != nil {
+		o.log.Warn("Failed to purge expired auth requests", zap.Error(err))
+	}
+
+	if err := o.model.CreateAuthRequest(request); err != nil {
+		o.log.Error("Failed to store auth request", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to start sign in")
+	}
+
+	o.setBindingCookie(c, binding, authRequestLifetime)
+	return helpers.Response(c, http.StatusOK, LoginResponse{URL: authURL}, "")
+}
+
+func (o *Handler) Callback(c echo.Context) error {
+	o.log.Debug("OIDCHandler: Callback")
+
+	if providerErr := c.QueryParam("error"); providerErr != "" {
+		o.log.Debug("Identity provider returned an error", zap.String("error", providerErr), zap.String("description", c.QueryParam("error_description")))
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Sign in was rejected by the identity provider")
+	}
+
+	code := c.QueryParam("code")
+	state := c.QueryParam("state")
+	if code == "" || state == "" {
+		return helpers.Response(c, http.StatusBadRequest, nil, "Invalid request")
+	}
+
+	request, err := o.model.ConsumeAuthRequest(state)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusBadRequest, nil, "Sign in request expired or is invalid")
+		}
+		o.log.Error("Failed to get auth request", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to sign in")
+	}
+
+	// The request is consumed either way, a mismatched callback can't be
+	// retried with the same state
+	cookie, err := c.Cookie(bindingCookie)
+	o.setBindingCookie(c, "", -time.Second)
+	if err != nil || subtle.ConstantTimeCompare([]byte(hashBinding(cookie.Value)), []byte(request.Binding)) != 1 {
+		return helpers.Response(c, http.StatusForbidden, nil, "Sign in was started from another browser")
+	}
+
+	token, err := o.provider.Exchange(code, request.CodeVerifier)
+	if err != nil {
+		o.log.Error("Failed to exchange authorization code", zap.Error(err))
+		return helpers.Response(c, http.StatusBadGateway, nil, "Failed to exchange authorization code")
+	}
+
+	claims, err := o.provider.VerifyIDToken(token.IDToken, request.Nonce)
+	if err != nil {
+		o.log.Error("Failed to verify ID token", zap.Error(err))
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Invalid ID token")
+	}
+
+	if request.LinkUserID != uuid.Nil {
+		return o.link(c, request.LinkUserID, claims)
+	}
+
+	user, err := o.resolveUser(claims)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusForbidden, nil, "No account is linked to this identity")
+		}
+		o.log.Error("Failed to resolve user", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to sign in")
+	}
+
+	if user.PendingApproval {
+		return helpers.Response(c, http.StatusForbidden, nil, "Account is awaiting approval")
+	}
+
+	if ban, err := account.NewBanModel(o.db).GetActive(user.ID); err == nil {
+		return helpers.Response(c, http.StatusForbidden, ban.ToPublicResponse(), account.BanMessage(ban))
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		o.log.Error("Failed to check bans", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to sign in")
+	}
+
+	jwt, err := helpers.GenerateJWT(user.ID, user.Name, o.cfg.JWT.Keys, o.cfg.AppConfig.TokenLifetime)
+	if err != nil {
+		o.log.Error("Failed to generate token", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to generate token")
+	}
+
+	return helpers.Response(c, http.StatusOK, jwt, "")
+}
+
+func (o *Handler) setBindingCookie(c echo.Context, value string, maxAge time.Duration) {
+	c.SetCookie(&http.Cookie{
+		Name:     bindingCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   !o.cfg.AppConfig.Development,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// hashBinding keeps the cookie value itself out of the database
+func hashBinding(binding string) string {
+	sum := sha256.Sum256([]byte(binding))
+	return hex.EncodeToString(sum[:])
+}
+
+func (o *Handler) GetIdentities(c echo.Context) error {
+	o.log.Debug("OIDCHandler: GetIdentities")
+	userID, err := helpers.GetUserID(c, o.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	data, err := o.model.GetIdentitiesByUserID(userID)
+	if err != nil {
+		o.log.Error("Failed to get identities", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get identities")
+	}
+
+	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
+}
+
+func (o *Handler) Unlink(c echo.Context) error {
+	o.log.Debug("OIDCHandler: Unlink")
+	userID, err := helpers.GetUserID(c, o.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
+	}
+
+	if err := o.model.DeleteIdentity(id, userID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Identity not found")
+		}
+		if errors.Is(err, ErrLastIdentity) {
+			return helpers.Response(c, http.StatusConflict, nil, err.Error())
+		}
+		o.log.Error("Failed to unlink identity", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to unlink identity")
+	}
+
+	audit.Record(o.db, o.log, c, audit.Log{
+		ActorID:    userID,
+		Action:     audit.ActionIdentityUnlinked,
+		TargetType: audit.TargetIdentity,
+		TargetID:   id.String(),
+	})
+
+	return helpers.Response(c, http.StatusOK, nil, "")
+}
+
+func (o *Handler) link(c echo.Context, userID uuid.UUID, claims IDTokenClaims) error {
+	existing, err := o.model.GetIdentity(claims.Issuer, claims.Subject)
+	if err == nil {
+		if existing.UserID == userID {
+			return helpers.Response(c, http.StatusOK, existing.ToResponse(), "")
+		}
+		return helpers.Response(c, http.StatusConflict, nil, "Identity is already linked to another account")
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		o.log.Error("Failed to get identity", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to link identity")
+	}
+
+	identity, err := o.model.CreateIdentity(Identity{
+		UserID:  userID,
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	})
+	if err != nil {
+		o.log.Error("Failed to link identity", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to link identity")
+	}
+
+	return helpers.Response(c, http.StatusOK, identity.ToResponse(), "")
+}
+
+// resolveUser finds the user linked to the identity, falling back to linking
+// by verified email and finally provisioning a new user when allowed
+func (o *Handler) resolveUser(claims IDTokenClaims) (account.User, error) {
+	userModel := account.NewUserModel(o.db)
+
+	identity, err := o.model.GetIdentity(claims.Issuer, claims.Subject)
+	if err == nil {
+		return userModel.GetByID(identity.UserID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return account.User{}, err
+	}
+
+	if o.cfg.OIDC.LinkByEmail && claims.EmailVerified && claims.Email != "" {
+		user, err := userModel.GetByNameOrEmail(claims.Email)
+		if err == nil && user.Email == claims.Email {
+			_, err := o.model.CreateIdentity(Identity{
+				UserID:  user.ID,
+				Issuer:  claims.Issuer,
+				Subject: claims.Subject,
+				Email:   claims.Email,
+			})
+			return user, err
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return account.User{}, err
+		}
+	}
+
+	if !o.cfg.OIDC.AutoProvision {
+		return account.User{}, gorm.ErrRecordNotFound
+	}
+
+	// Provisioning is registration, so it follows the registration mode. There
+	// is no way to pass an invite code through the provider.
+	mode, err := setting.NewModel(o.db).GetRegistrationMode()
+	if err != nil {
+		return account.User{}, err
+	}
+	if mode == setting.RegistrationClosed || mode == setting.RegistrationInvite {
+		return account.User{}, gorm.ErrRecordNotFound
+	}
+
+	return o.provision(claims, mode == setting.RegistrationApproval)
+}
+
+func (o *Handler) provision(claims IDTokenClaims, pendingApproval bool) (account.User, error) {
+	// Accounts created through OIDC get a random password nobody knows, so
+	// the only way in is through the identity provider
+	randomPassword, err := helpers.RandomString(32)
+	if err != nil {
+		return account.User{}, err
+	}
+	hashedPassword, err := o.cfg.Password.Hasher().Hash(randomPassword)
+	if err != nil {
+		return account.User{}, err
+	}
+
+	tx := o.db.Begin()
+
+	name, err := availableName(tx, claims)
+	if err != nil {
+		tx.Rollback()
+		return account.User{}, err
+	}
+
+	level := permission.Level(o.cfg.OIDC.DefaultPermission)
+	user := account.User{
+		Name:     name,
+		Password: hashedPassword,
+		Permission: permission.Permission{
+			Permission: level,
+		},
+		Roles:           permission.NewUserRoles(level.Roles()...),
+		PendingApproval: pendingApproval,
+		NoPassword:      true,
+	}
+
+	if claims.Email != "" && claims.EmailVerified {
+		var count int64
+		if err := tx.Unscoped().Model(&account.User{}).Where("email = ?", claims.Email).Count(&count).Error; err != nil {
+			tx.Rollback()
+			return account.User{}, err
+		}
+		if count == 0 {
+			user.Email = claims.Email
+		}
+	}
+
+	data, err := account.NewUserModel(tx).Create(user)
+	if err != nil {
+		tx.Rollback()
+		return account.User{}, err
+	}
+
+	if _, err := NewModel(tx).CreateIdentity(Identity{
+		UserID:  data.ID,
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	}); err != nil {
+		tx.Rollback()
+		return account.User{}, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return account.User{}, err
+	}
+
+	o.log.Info("Provisioned user from identity provider", zap.String("name", data.Name), zap.String("issuer", claims.Issuer))
+	return data, nil
+}
+
+func availableName(tx *gorm.DB, claims IDTokenClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base = claims.Name
+	}
+	if base == "" && claims.Email != "" {
+		base = strings.Split(claims.Email, "@")[0]
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	name := base
+	for i := 1; i <= 100; i++ {
+		var count int64
+		if err := tx.Unscoped().Model(&account.User{}).Where("name = ?", name).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return name, nil
+		}
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+
+	return "", errors.New("Unable to find a free user name for " + base)
+}
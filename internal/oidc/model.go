@@ -0,0 +1,140 @@
+package oidc
+
+import (
+	"errors"
+	"maribooru/internal/account"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type (
+	Identity struct {
+		ID        uuid.UUID    `gorm:"primary_key;type:uuid"`
+		UserID    uuid.UUID    `gorm:"type:uuid;not null;index"`
+		User      account.User `gorm:"foreignKey:UserID"`
+		Issuer    string       `gorm:"not null;uniqueIndex:idx_issuer_subject"`
+		Subject   string       `gorm:"not null;uniqueIndex:idx_issuer_subject"`
+		Email     string
+		CreatedAt time.Time
+		UpdatedAt time.Time
+	}
+
+	IdentitySlice []Identity
+
+	AuthRequest struct {
+		State        string    `gorm:"primary_key"`
+		Nonce        string    `gorm:"not null"`
+		CodeVerifier string    `gorm:"not null"`
+		Binding      string    `gorm:"not null"`
+		LinkUserID   uuid.UUID `gorm:"type:uuid;default:null"`
+		ExpiresAt    time.Time
+		CreatedAt    time.Time
+	}
+
+	Model struct {
+		db *gorm.DB
+	}
+)
+
+func (i *Identity) BeforeCreate(tx *gorm.DB) error {
+	i.ID = uuid.New()
+	return nil
+}
+
+// ErrLastIdentity is returned when unlinking the only way into an account
+// that has no password
+var ErrLastIdentity = errors.New("The last identity of an account without a password can't be unlinked")
+
+func NewModel(db *gorm.DB) *Model {
+	return &Model{
+		db: db,
+	}
+}
+
+func (m *Model) CreateAuthRequest(request AuthRequest) error {
+	return m.db.Create(&request).Error
+}
+
+// ConsumeAuthRequest returns the pending request for the given state and
+// deletes it, so a state value can only ever be redeemed once
+func (m *Model) ConsumeAuthRequest(state string) (AuthRequest, error) {
+	request := AuthRequest{}
+	err := m.db.Model(&AuthRequest{}).
+		Where("state = ? AND expires_at > ?", state, time.Now()).
+		First(&request).
+		Error
+	if err != nil {
+		return AuthRequest{}, err
+	}
+
+	res := m.db.Where("state = ?", state).Delete(&AuthRequest{})
+	if res.Error != nil {
+		return AuthRequest{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return AuthRequest{}, gorm.ErrRecordNotFound
+	}
+	return request, nil
+}
+
+func (m *Model) PurgeExpiredAuthRequests() error {
+	return m.db.Where("expires_at <= ?", time.Now()).Delete(&AuthRequest{}).Error
+}
+
+func (m *Model) GetIdentity(issuer, subject string) (Identity, error) {
+	identity := Identity{}
+	err := m.db.Model(&Identity{}).
+		Where("issuer = ? AND subject = ?", issuer, subject).
+		First(&identity).
+		Error
+	return identity, err
+}
+
+func (m *Model) GetIdentitiesByUserID(userID uuid.UUID) (IdentitySlice, error) {
+	identities := IdentitySlice{}
+	err := m.db.Model(&Identity{}).
+		Where("user_id = ?", userID).
+		Order("created_at asc").
+		Find(&identities).
+		Error
+	return identities, err
+}
+
+func (m *Model) CreateIdentity(identity Identity) (Identity, error) {
+	err := m.db.Create(&identity).Clauses(clause.Returning{}).Error
+	return identity, err
+}
+
+// DeleteIdentity unlinks an identity, refusing to remove the last one of a
+// user without a password since they couldn't sign in anymore
+func (m *Model) DeleteIdentity(id, userID uuid.UUID) error {
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("id = ? AND user_id = ?", id, userID).Delete(&Identity{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		user, err := account.NewUserModel(tx).GetByID(userID)
+		if err != nil {
+			return err
+		}
+		if !user.NoPassword {
+			return nil
+		}
+
+		remaining := int64(0)
+		if err := tx.Model(&Identity{}).Where("user_id = ?", userID).Count(&remaining).Error; err != nil {
+			return err
+		}
+		if remaining == 0 {
+			return ErrLastIdentity
+		}
+		return nil
+	})
+}
@@ -0,0 +1,265 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type (
+	Discovery struct {
+		Issuer                string   `json:"issuer"`
+		AuthorizationEndpoint string   `json:"authorization_endpoint"`
+		TokenEndpoint         string   `json:"token_endpoint"`
+		JWKSURI               string   `json:"jwks_uri"`
+		IDTokenSigningAlgs    []string `json:"id_token_signing_alg_values_supported"`
+	}
+
+	TokenResponse struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		IDToken     string `json:"id_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+
+	IDTokenClaims struct {
+		Nonce             string `json:"nonce"`
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+		AuthorizedParty   string `json:"azp"`
+		jwt.RegisteredClaims
+	}
+
+	Provider struct {
+		cfg       config.OIDC
+		client    *http.Client
+		mu        sync.Mutex
+		discovery *Discovery
+		keys      helpers.JWKSet
+	}
+)
+
+var (
+	ErrInvalidNonce    = errors.New("ID token nonce does not match")
+	ErrInvalidAudience = errors.New("ID token was not issued for this client")
+)
+
+func NewProvider(cfg config.OIDC, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{
+		cfg:    cfg,
+		client: client,
+	}
+}
+
+// Discover fetches the issuer metadata once and caches it for the lifetime of
+// the provider. A failed lookup is retried on the next call.
+func (p *Provider) Discover() (*Discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	discovery := Discovery{}
+	if err := p.getJSON(wellKnown, &discovery); err != nil {
+		return nil, err
+	}
+
+	if discovery.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("Issuer mismatch, expected %s got %s", p.cfg.Issuer, discovery.Issuer)
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	discovery, err := p.Discover()
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURL)
+	query.Set("scope", p.cfg.Scopes)
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", CodeChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+func (p *Provider) Exchange(code, verifier string) (TokenResponse, error) {
+	token := TokenResponse{}
+	discovery, err := p.Discover()
+	if err != nil {
+		return token, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return token, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return token, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return token, fmt.Errorf("Token endpoint returned %d", res.StatusCode)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return token, err
+	}
+	if token.IDToken == "" {
+		return token, errors.New("Token response has no ID token")
+	}
+
+	return token, nil
+}
+
+func (p *Provider) VerifyIDToken(raw, nonce string) (IDTokenClaims, error) {
+	claims := IDTokenClaims{}
+	discovery, err := p.Discover()
+	if err != nil {
+		return claims, err
+	}
+
+	algs := discovery.IDTokenSigningAlgs
+	if len(algs) == 0 {
+		algs = []string{"RS256"}
+	}
+
+	_, err = jwt.ParseWithClaims(raw, &claims, p.keyFunc,
+		jwt.WithValidMethods(algs),
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return claims, err
+	}
+
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.cfg.ClientID {
+		return claims, ErrInvalidAudience
+	}
+
+	if claims.Nonce != nonce {
+		return claims, ErrInvalidNonce
+	}
+
+	if claims.Subject == "" {
+		return claims, errors.New("ID token has no subject")
+	}
+
+	return claims, nil
+}
+
+func (p *Provider) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	p.mu.Lock()
+	key, found := p.findKey(kid)
+	p.mu.Unlock()
+	if found {
+		return key.PublicKey()
+	}
+
+	// Unknown key id, the issuer may have rotated its keys
+	if err := p.refreshKeys(); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	key, found = p.findKey(kid)
+	p.mu.Unlock()
+	if !found {
+		return nil, fmt.Errorf("Signing key %q not found", kid)
+	}
+	return key.PublicKey()
+}
+
+func (p *Provider) findKey(kid string) (helpers.JWK, bool) {
+	if kid == "" && len(p.keys.Keys) == 1 {
+		return p.keys.Keys[0], true
+	}
+	return p.keys.Find(kid)
+}
+
+func (p *Provider) refreshKeys() error {
+	discovery, err := p.Discover()
+	if err != nil {
+		return err
+	}
+
+	keys := helpers.JWKSet{}
+	if err := p.getJSON(discovery.JWKSURI, &keys); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *Provider) getJSON(url string, target interface{}) error {
+	res, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(target)
+}
+
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
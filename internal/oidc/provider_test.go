@@ -0,0 +1,268 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"maribooru/internal/account"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"maribooru/internal/permission"
+	"maribooru/internal/setting"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gopkg.in/go-playground/assert.v1"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type mockIssuer struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	clientID  string
+	subject   string
+	challenge string
+	nonce     string
+}
+
+func newMockIssuer(t *testing.T, clientID string) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockIssuer{
+		key:      key,
+		clientID: clientID,
+		subject:  "mock-subject",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Discovery{
+			Issuer:                m.server.URL,
+			AuthorizationEndpoint: m.server.URL + "/authorize",
+			TokenEndpoint:         m.server.URL + "/token",
+			JWKSURI:               m.server.URL + "/jwks",
+			IDTokenSigningAlgs:    []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(helpers.JWKSet{Keys: []helpers.JWK{{
+			Kty: "RSA",
+			Kid: "mock",
+			Alg: "RS256",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "mock-code" || CodeChallenge(r.Form.Get("code_verifier")) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(TokenResponse{
+			AccessToken: "mock-access-token",
+			TokenType:   "Bearer",
+			IDToken:     m.idToken(t, m.nonce),
+		})
+	})
+
+	m.server = httptest.NewServer(mux)
+	return m
+}
+
+func (m *mockIssuer) idToken(t *testing.T, nonce string) string {
+	claims := IDTokenClaims{
+		Nonce:             nonce,
+		Email:             "mock@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "mock",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.server.URL,
+			Subject:   m.subject,
+			Audience:  jwt.ClaimStrings{m.clientID},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "mock"
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	issuer := newMockIssuer(t, "maribooru")
+	defer issuer.server.Close()
+
+	provider := NewProvider(config.OIDC{
+		Issuer:   issuer.server.URL,
+		ClientID: "maribooru",
+	}, nil)
+
+	claims, err := provider.VerifyIDToken(issuer.idToken(t, "nonce"), "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, issuer.subject, claims.Subject)
+
+	_, err = provider.VerifyIDToken(issuer.idToken(t, "nonce"), "other-nonce")
+	assert.Equal(t, ErrInvalidNonce, err)
+
+	other := NewProvider(config.OIDC{
+		Issuer:   issuer.server.URL,
+		ClientID: "someone-else",
+	}, nil)
+	_, err = other.VerifyIDToken(issuer.idToken(t, "nonce"), "nonce")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestCallbackProvisionsUser(t *testing.T) {
+	issuer := newMockIssuer(t, "maribooru")
+	defer issuer.server.Close()
+
+	cfg := &config.Config{
+		AppConfig: config.AppConfig{TokenLifetime: time.Minute},
+		JWT:       config.JWT{Secret: "secret", Keys: helpers.NewHMACKeys("secret")},
+		OIDC: config.OIDC{
+			Enabled:           true,
+			Issuer:            issuer.server.URL,
+			ClientID:          "maribooru",
+			RedirectURL:       "http://localhost/callback",
+			Scopes:            "openid profile email",
+			AutoProvision:     true,
+			DefaultPermission: int(permission.Read | permission.Write),
+		},
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(account.User{}, account.Admin{}, permission.Permission{}, permission.Role{}, permission.RoleCapability{}, permission.UserRole{}, account.Ban{}, setting.AppSetting{}, Identity{}, AuthRequest{})
+
+	log, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	handler := NewHandler(db, cfg, log)
+
+	req := httptest.NewRequest(echo.GET, "/", nil)
+	rec := httptest.NewRecorder()
+	if err := handler.Login(e.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var login struct {
+		Data LoginResponse `json:"data"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &login)
+	authURL, err := url.Parse(login.Data.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "S256", authURL.Query().Get("code_challenge_method"))
+	cookies := rec.Result().Cookies()
+	assert.Equal(t, 1, len(cookies))
+	assert.Equal(t, bindingCookie, cookies[0].Name)
+	assert.Equal(t, true, cookies[0].HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+	binding := cookies[0]
+
+	issuer.challenge = authURL.Query().Get("code_challenge")
+	issuer.nonce = authURL.Query().Get("nonce")
+
+	callback := url.Values{}
+	callback.Set("code", "mock-code")
+	callback.Set("state", authURL.Query().Get("state"))
+
+	req = httptest.NewRequest(echo.GET, "/?"+callback.Encode(), nil)
+	req.AddCookie(binding)
+	rec = httptest.NewRecorder()
+	if err := handler.Callback(e.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	identity, err := NewModel(db).GetIdentity(issuer.server.URL, issuer.subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := account.NewUserModel(db).GetByID(identity.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "mock", user.Name)
+	assert.Equal(t, "mock@example.com", user.Email)
+	assert.Equal(t, permission.Read|permission.Write, user.Permission.Permission)
+	assert.Equal(t, true, user.NoPassword)
+	assert.Equal(t, false, helpers.DefaultPasswordHasher.NeedsRehash(user.Password))
+
+	// Without a password the only identity can't be unlinked
+	token, err := helpers.GenerateJWT(user.ID, user.Name, cfg.JWT.Keys, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest(echo.DELETE, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec = httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(identity.ID.String())
+	if err := handler.Unlink(c); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	if _, err := NewModel(db).GetIdentity(issuer.server.URL, issuer.subject); err != nil {
+		t.Fatal(err)
+	}
+
+	// The state has been consumed and cannot be replayed
+	req = httptest.NewRequest(echo.GET, "/?"+callback.Encode(), nil)
+	req.AddCookie(binding)
+	rec = httptest.NewRecorder()
+	handler.Callback(e.NewContext(req, rec))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// A callback from a browser that didn't start the flow is refused and
+	// burns the state
+	req = httptest.NewRequest(echo.GET, "/", nil)
+	rec = httptest.NewRecorder()
+	if err := handler.Login(e.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal(rec.Body.Bytes(), &login)
+	authURL, err = url.Parse(login.Data.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback.Set("state", authURL.Query().Get("state"))
+
+	req = httptest.NewRequest(echo.GET, "/?"+callback.Encode(), nil)
+	rec = httptest.NewRecorder()
+	handler.Callback(e.NewContext(req, rec))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	count := int64(0)
+	db.Model(&AuthRequest{}).Where("state = ?", callback.Get("state")).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
@@ -0,0 +1,183 @@
+package permission
+
+type (
+	Capability string
+
+	CapabilitySet map[Capability]bool
+
+	// Requirement is anything a route can be guarded by, passing when the user
+	// has any of the listed capabilities
+	Requirement interface {
+		Capabilities() []Capability
+	}
+
+	BuiltInRole struct {
+		Name         string
+		Description  string
+		Capabilities []Capability
+	}
+)
+
+const (
+	CapRead        Capability = "read"
+	CapWrite       Capability = "write"
+	CapApprove     Capability = "approve"
+	CapModerate    Capability = "moderate"
+	CapInvite      Capability = "invite"
+	CapTagDelete   Capability = "tag.delete"
+	CapTagCategory Capability = "tag_category.manage"
+	CapUserManage  Capability = "user.manage"
+	CapAdmin       Capability = "admin"
+)
+
+const (
+	RoleMember      = "member"
+	RoleContributor = "contributor"
+	RoleApprover    = "approver"
+	RoleJanitor     = "janitor"
+	RoleModerator   = "moderator"
+	RoleAdmin       = "admin"
+)
+
+var AllCapabilities = []Capability{
+	CapRead,
+	CapWrite,
+	CapApprove,
+	CapModerate,
+	CapInvite,
+	CapTagDelete,
+	CapTagCategory,
+	CapUserManage,
+	CapAdmin,
+}
+
+var BuiltInRoles = []BuiltInRole{
+	{
+		Name:         RoleMember,
+		Description:  "Can browse",
+		Capabilities: []Capability{CapRead},
+	},
+	{
+		Name:         RoleContributor,
+		Description:  "Can browse and contribute",
+		Capabilities: []Capability{CapRead, CapWrite},
+	},
+	{
+		Name:         RoleApprover,
+		Description:  "Can approve contributions and invite users",
+		Capabilities: []Capability{CapRead, CapWrite, CapApprove, CapInvite},
+	},
+	{
+		Name:         RoleJanitor,
+		Description:  "Can approve contributions and clean up tags",
+		Capabilities: []Capability{CapRead, CapWrite, CapApprove, CapInvite, CapTagDelete, CapTagCategory},
+	},
+	{
+		Name:         RoleModerator,
+		Description:  "Can moderate users and content",
+		Capabilities: []Capability{CapRead, CapWrite, CapApprove, CapInvite, CapTagDelete, CapTagCategory, CapModerate},
+	},
+	{
+		Name:         RoleAdmin,
+		Description:  "Can do everything",
+		Capabilities: []Capability{CapAdmin},
+	},
+}
+
+// levelRoles maps each legacy permission bit onto the role that replaces it
+var levelRoles = map[Level]string{
+	Read:     RoleMember,
+	Write:    RoleContributor,
+	Approve:  RoleApprover,
+	Moderate: RoleModerator,
+}
+
+func (c Capability) Capabilities() []Capability {
+	return []Capability{c}
+}
+
+// Capabilities lists what each set bit grants on its own, so a Level keeps
+// working as a Requirement for routes that haven't moved to capabilities
+func (l Level) Capabilities() []Capability {
+	capabilities := []Capability{}
+	for _, bit := range []Level{Read, Write, Approve, Moderate} {
+		if l&bit == 0 {
+			continue
+		}
+		switch bit {
+		case Read:
+			capabilities = append(capabilities, CapRead)
+		case Write:
+			capabilities = append(capabilities, CapWrite)
+		case Approve:
+			capabilities = append(capabilities, CapApprove)
+		case Moderate:
+			capabilities = append(capabilities, CapModerate)
+		}
+	}
+	return capabilities
+}
+
+// Roles lists the roles equivalent to the set bits
+func (l Level) Roles() []string {
+	roles := []string{}
+	for _, bit := range []Level{Read, Write, Approve, Moderate} {
+		if l&bit != 0 {
+			roles = append(roles, levelRoles[bit])
+		}
+	}
+	return roles
+}
+
+// LevelFromRoles is the reverse of Level.Roles, roles that don't mirror a
+// bit are ignored
+func LevelFromRoles(names ...string) Level {
+	level := Level(0)
+	for bit, role := range levelRoles {
+		for _, name := range names {
+			if name == role {
+				level |= bit
+			}
+		}
+	}
+	return level
+}
+
+func IsCapability(c Capability) bool {
+	for _, capability := range AllCapabilities {
+		if capability == c {
+			return true
+		}
+	}
+	return false
+}
+
+func IsBuiltInRole(name string) bool {
+	for _, role := range BuiltInRoles {
+		if role.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func NewCapabilitySet(capabilities ...Capability) CapabilitySet {
+	set := CapabilitySet{}
+	for _, capability := range capabilities {
+		set[capability] = true
+	}
+	return set
+}
+
+// HasAny reports whether any required capability is held, admin holds all
+func (s CapabilitySet) HasAny(required Requirement) bool {
+	if s[CapAdmin] {
+		return true
+	}
+	for _, capability := range required.Capabilities() {
+		if s[capability] {
+			return true
+		}
+	}
+	return false
+}
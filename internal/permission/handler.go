@@ -2,6 +2,7 @@ package permission
 
 import (
 	"errors"
+	"maribooru/internal/audit"
 	"maribooru/internal/config"
 	"maribooru/internal/helpers"
 	"net/http"
@@ -84,6 +85,10 @@ func (p *Handler) GetByUserID(c echo.Context) error {
 
 func (p *Handler) Set(c echo.Context) error {
 	p.log.Debug("PermissionHandler: SetPermission")
+	actorID, err := helpers.GetUserID(c, p.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
 
 	var request Request
 	if err := c.Bind(&request); err != nil {
@@ -92,10 +97,36 @@ func (p *Handler) Set(c echo.Context) error {
 	if err := c.Validate(&request); err != nil {
 		return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
 	}
-	data, err := p.model.SetPermission(request.ToTable())
+	// Keep the roles mirroring the bits in step, so lowering the bits also
+	// takes away what the old roles granted
+	before, err := p.model.GetByUserID(request.UserID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		p.log.Debug("Failed to get permission", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "There was an error while setting permission")
+	}
+
+	var data Permission
+	err = p.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		data, err = NewModel(tx).SetPermission(request.ToTable())
+		if err != nil {
+			return err
+		}
+		return NewRoleModel(tx).SyncLevelRoles(request.UserID, request.Permission)
+	})
 	if err != nil {
 		p.log.Debug("Failed to set permission", zap.Error(err))
 		return helpers.Response(c, http.StatusInternalServerError, nil, "There was an error while setting permission")
 	}
+
+	audit.Record(p.db, p.log, c, audit.Log{
+		ActorID:    actorID,
+		Action:     audit.ActionPermissionSet,
+		TargetType: audit.TargetUser,
+		TargetID:   request.UserID.String(),
+		Before:     audit.ToJSON(before.ToResponse()),
+		After:      audit.ToJSON(data.ToResponse()),
+	})
+
 	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
 }
@@ -0,0 +1,333 @@
+package permission
+
+import (
+	"errors"
+	"maribooru/internal/audit"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type (
+	RoleRequest struct {
+		Name         string       `json:"name" validate:"required,max=32"`
+		Description  string       `json:"description" validate:"max=255"`
+		Capabilities []Capability `json:"capabilities" validate:"required"`
+	}
+
+	UserRolesRequest struct {
+		Roles []string `json:"roles"`
+	}
+
+	RoleResponse struct {
+		Name         string       `json:"name"`
+		Description  string       `json:"description"`
+		BuiltIn      bool         `json:"built_in"`
+		Capabilities []Capability `json:"capabilities"`
+		UpdatedAt    time.Time    `json:"updated_at"`
+	}
+
+	UserRolesResponse struct {
+		UserID       uuid.UUID    `json:"user_id"`
+		Roles        []string     `json:"roles"`
+		Capabilities []Capability `json:"capabilities"`
+	}
+
+	RoleHandler struct {
+		db    *gorm.DB
+		model *RoleModel
+		cfg   *config.Config
+		log   *zap.Logger
+	}
+)
+
+func (r *RoleRequest) ToTable() Role {
+	role := Role{
+		Name:        r.Name,
+		Description: r.Description,
+	}
+	for _, capability := range r.Capabilities {
+		role.Capabilities = append(role.Capabilities, RoleCapability{RoleName: r.Name, Capability: capability})
+	}
+	return role
+}
+
+func (r *Role) ToResponse() RoleResponse {
+	capabilities := make([]Capability, 0)
+	for _, capability := range r.Capabilities {
+		capabilities = append(capabilities, capability.Capability)
+	}
+	return RoleResponse{
+		Name:         r.Name,
+		Description:  r.Description,
+		BuiltIn:      r.BuiltIn,
+		Capabilities: capabilities,
+		UpdatedAt:    r.UpdatedAt,
+	}
+}
+
+func (r RoleSlice) ToResponse() []RoleResponse {
+	response := make([]RoleResponse, 0)
+	for _, role := range r {
+		response = append(response, role.ToResponse())
+	}
+	return response
+}
+
+func NewRoleHandler(db *gorm.DB, cfg *config.Config, log *zap.Logger) *RoleHandler {
+	return &RoleHandler{
+		db:    db,
+		model: NewRoleModel(db),
+		cfg:   cfg,
+		log:   log,
+	}
+}
+
+func (r *RoleHandler) GetCapabilities(c echo.Context) error {
+	r.log.Debug("RoleHandler: GetCapabilities")
+	return helpers.Response(c, http.StatusOK, AllCapabilities, "")
+}
+
+func (r *RoleHandler) GetAll(c echo.Context) error {
+	r.log.Debug("RoleHandler: GetAll")
+	data, err := r.model.GetAll()
+	if err != nil {
+		r.log.Error("Failed to get roles", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get roles")
+	}
+	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
+}
+
+func (r *RoleHandler) Create(c echo.Context) error {
+	r.log.Debug("RoleHandler: Create")
+	actorID, err := helpers.GetUserID(c, r.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	request, err := r.bindRole(c)
+	if err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
+	}
+
+	data, err := r.model.Create(request.ToTable())
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return helpers.Response(c, http.StatusConflict, nil, "Role already exists")
+		}
+		r.log.Error("Failed to create role", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to create role")
+	}
+
+	audit.Record(r.db, r.log, c, audit.Log{
+		ActorID:    actorID,
+		Action:     audit.ActionRoleCreated,
+		TargetType: audit.TargetRole,
+		TargetID:   data.Name,
+		After:      audit.ToJSON(data.ToResponse()),
+	})
+
+	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
+}
+
+func (r *RoleHandler) Update(c echo.Context) error {
+	r.log.Debug("RoleHandler: Update")
+	actorID, err := helpers.GetUserID(c, r.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	request, err := r.bindRole(c)
+	if err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
+	}
+
+	// Editing the admin role could lock every admin out
+	if request.Name == RoleAdmin {
+		return helpers.Response(c, http.StatusForbidden, nil, "The admin role can't be changed")
+	}
+
+	before, err := r.model.GetByName(request.Name)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Role not found")
+		}
+		r.log.Error("Failed to get role", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to update role")
+	}
+
+	data, err := r.model.Update(request.ToTable())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Role not found")
+		}
+		r.log.Error("Failed to update role", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to update role")
+	}
+
+	audit.Record(r.db, r.log, c, audit.Log{
+		ActorID:    actorID,
+		Action:     audit.ActionRoleUpdated,
+		TargetType: audit.TargetRole,
+		TargetID:   data.Name,
+		Before:     audit.ToJSON(before.ToResponse()),
+		After:      audit.ToJSON(data.ToResponse()),
+	})
+
+	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
+}
+
+func (r *RoleHandler) Delete(c echo.Context) error {
+	r.log.Debug("RoleHandler: Delete")
+	actorID, err := helpers.GetUserID(c, r.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	name := c.Param("name")
+	if IsBuiltInRole(name) {
+		return helpers.Response(c, http.StatusForbidden, nil, "Built in roles can't be deleted")
+	}
+
+	before, err := r.model.GetByName(name)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Role not found")
+		}
+		r.log.Error("Failed to get role", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to delete role")
+	}
+
+	if err := r.model.Delete(name); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Role not found")
+		}
+		r.log.Error("Failed to delete role", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to delete role")
+	}
+
+	audit.Record(r.db, r.log, c, audit.Log{
+		ActorID:    actorID,
+		Action:     audit.ActionRoleDeleted,
+		TargetType: audit.TargetRole,
+		TargetID:   name,
+		Before:     audit.ToJSON(before.ToResponse()),
+	})
+
+	return helpers.Response(c, http.StatusOK, nil, "")
+}
+
+func (r *RoleHandler) GetUserRoles(c echo.Context) error {
+	r.log.Debug("RoleHandler: GetUserRoles")
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
+	}
+	return r.userRolesResponse(c, id)
+}
+
+func (r *RoleHandler) SetUserRoles(c echo.Context) error {
+	r.log.Debug("RoleHandler: SetUserRoles")
+	actorID, err := helpers.GetUserID(c, r.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
+	}
+
+	var request UserRolesRequest
+	if err := c.Bind(&request); err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "Invalid request")
+	}
+
+	roles, err := r.model.GetAll()
+	if err != nil {
+		r.log.Error("Failed to get roles", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to set roles")
+	}
+	known := map[string]bool{}
+	for _, role := range roles {
+		known[role.Name] = true
+	}
+	for _, name := range request.Roles {
+		if !known[name] {
+			return helpers.Response(c, http.StatusBadRequest, nil, "Unknown role "+name)
+		}
+	}
+
+	before, err := r.model.GetUserRoles(id)
+	if err != nil {
+		r.log.Error("Failed to get user roles", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to set roles")
+	}
+	beforeNames := make([]string, 0)
+	for _, role := range before {
+		beforeNames = append(beforeNames, role.RoleName)
+	}
+
+	if err := r.model.SetUserRoles(id, request.Roles); err != nil {
+		r.log.Error("Failed to set roles", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to set roles")
+	}
+
+	audit.Record(r.db, r.log, c, audit.Log{
+		ActorID:    actorID,
+		Action:     audit.ActionUserRolesSet,
+		TargetType: audit.TargetUser,
+		TargetID:   id.String(),
+		Before:     audit.ToJSON(UserRolesRequest{Roles: beforeNames}),
+		After:      audit.ToJSON(request),
+	})
+	return r.userRolesResponse(c, id)
+}
+
+func (r *RoleHandler) userRolesResponse(c echo.Context, id uuid.UUID) error {
+	roles, err := r.model.GetUserRoles(id)
+	if err != nil {
+		r.log.Error("Failed to get user roles", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get user roles")
+	}
+
+	response := UserRolesResponse{
+		UserID:       id,
+		Roles:        make([]string, 0),
+		Capabilities: make([]Capability, 0),
+	}
+	seen := CapabilitySet{}
+	for _, role := range roles {
+		response.Roles = append(response.Roles, role.RoleName)
+		for _, capability := range role.Role.Capabilities {
+			if seen[capability.Capability] {
+				continue
+			}
+			seen[capability.Capability] = true
+			response.Capabilities = append(response.Capabilities, capability.Capability)
+		}
+	}
+	return helpers.Response(c, http.StatusOK, response, "")
+}
+
+func (r *RoleHandler) bindRole(c echo.Context) (RoleRequest, error) {
+	var request RoleRequest
+	if err := c.Bind(&request); err != nil {
+		return request, errors.New("Invalid request")
+	}
+	if err := c.Validate(&request); err != nil {
+		return request, err
+	}
+	for _, capability := range request.Capabilities {
+		if !IsCapability(capability) {
+			return request, errors.New("Unknown capability " + string(capability))
+		}
+	}
+	return request, nil
+}
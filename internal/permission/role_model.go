@@ -0,0 +1,282 @@
+package permission
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type (
+	Role struct {
+		Name         string           `gorm:"primary_key"`
+		Description  string           `gorm:"default:''"`
+		BuiltIn      bool             `gorm:"not null;default:false"`
+		Capabilities []RoleCapability `gorm:"foreignKey:RoleName;constraint:OnDelete:CASCADE"`
+		CreatedAt    time.Time
+		UpdatedAt    time.Time
+	}
+
+	RoleCapability struct {
+		RoleName   string     `gorm:"primary_key"`
+		Capability Capability `gorm:"primary_key"`
+	}
+
+	UserRole struct {
+		UserID    uuid.UUID `gorm:"type:uuid;primary_key"`
+		RoleName  string    `gorm:"primary_key"`
+		Role      Role      `gorm:"foreignKey:RoleName"`
+		CreatedAt time.Time
+	}
+
+	// TrashedUserRole keeps the roles of a deleted user aside, they grant
+	// nothing there and come back when the user is restored from the trash
+	TrashedUserRole struct {
+		UserID    uuid.UUID `gorm:"type:uuid;primary_key"`
+		RoleName  string    `gorm:"primary_key"`
+		CreatedAt time.Time
+	}
+
+	RoleSlice []Role
+
+	RoleModel struct {
+		db *gorm.DB
+	}
+)
+
+func NewRoleModel(db *gorm.DB) *RoleModel {
+	return &RoleModel{
+		db: db,
+	}
+}
+
+// NewUserRoles builds role assignments to attach to a user before it's created
+func NewUserRoles(names ...string) []UserRole {
+	roles := []UserRole{}
+	for _, name := range names {
+		roles = append(roles, UserRole{RoleName: name})
+	}
+	return roles
+}
+
+func (r *RoleModel) GetAll() (RoleSlice, error) {
+	roles := RoleSlice{}
+	err := r.db.Model(&Role{}).Preload("Capabilities").Order("name").Find(&roles).Error
+	return roles, err
+}
+
+func (r *RoleModel) GetByName(name string) (Role, error) {
+	role := Role{}
+	err := r.db.Model(&Role{}).Preload("Capabilities").Where("name = ?", name).First(&role).Error
+	return role, err
+}
+
+func (r *RoleModel) Create(role Role) (Role, error) {
+	err := r.db.Create(&role).Error
+	return role, err
+}
+
+// Update replaces the description and capabilities of the role
+func (r *RoleModel) Update(role Role) (Role, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&Role{}).Where("name = ?", role.Name).Update("description", role.Description)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := tx.Where("role_name = ?", role.Name).Delete(&RoleCapability{}).Error; err != nil {
+			return err
+		}
+		if len(role.Capabilities) == 0 {
+			return nil
+		}
+		return tx.Create(&role.Capabilities).Error
+	})
+	if err != nil {
+		return Role{}, err
+	}
+	return r.GetByName(role.Name)
+}
+
+func (r *RoleModel) Delete(name string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role_name = ?", name).Delete(&UserRole{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_name = ?", name).Delete(&TrashedUserRole{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_name = ?", name).Delete(&RoleCapability{}).Error; err != nil {
+			return err
+		}
+		res := tx.Where("name = ? AND built_in = ?", name, false).Delete(&Role{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// SeedBuiltIn creates the built in roles that don't exist yet, roles that do
+// exist are left alone so edits made by admins survive restarts
+func (r *RoleModel) SeedBuiltIn() error {
+	for _, builtIn := range BuiltInRoles {
+		role := Role{
+			Name:        builtIn.Name,
+			Description: builtIn.Description,
+			BuiltIn:     true,
+		}
+		for _, capability := range builtIn.Capabilities {
+			role.Capabilities = append(role.Capabilities, RoleCapability{RoleName: builtIn.Name, Capability: capability})
+		}
+
+		res := r.db.Omit("Capabilities").Clauses(clause.OnConflict{DoNothing: true}).Create(&role)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		if err := r.db.Create(&role.Capabilities).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RoleModel) GetUserRoles(userID uuid.UUID) ([]UserRole, error) {
+	roles := []UserRole{}
+	err := r.db.Model(&UserRole{}).
+		Preload("Role.Capabilities").
+		Where("user_id = ?", userID).
+		Order("role_name").
+		Find(&roles).
+		Error
+	return roles, err
+}
+
+func (r *RoleModel) GetCapabilitiesByUserID(userID uuid.UUID) (CapabilitySet, error) {
+	capabilities := []Capability{}
+	err := r.db.Model(&RoleCapability{}).
+		Distinct("role_capabilities.capability").
+		Joins("JOIN user_roles ON user_roles.role_name = role_capabilities.role_name").
+		Where("user_roles.user_id = ?", userID).
+		Pluck("role_capabilities.capability", &capabilities).
+		Error
+	return NewCapabilitySet(capabilities...), err
+}
+
+func (r *RoleModel) AssignRoles(userID uuid.UUID, names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	roles := []UserRole{}
+	for _, name := range names {
+		roles = append(roles, UserRole{UserID: userID, RoleName: name})
+	}
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&roles).Error
+}
+
+func (r *RoleModel) RemoveRoles(userID uuid.UUID, names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	return r.db.Where("user_id = ? AND role_name IN ?", userID, names).Delete(&UserRole{}).Error
+}
+
+// SetUserRoles replaces every role of the user and rewrites the permission
+// bits to the ones mirrored by the new roles
+func (r *RoleModel) SetUserRoles(userID uuid.UUID, names []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&UserRole{}).Error; err != nil {
+			return err
+		}
+		if err := NewRoleModel(tx).AssignRoles(userID, names...); err != nil {
+			return err
+		}
+
+		res := tx.Model(&Permission{}).Where("user_id = ?", userID).Update("permission", LevelFromRoles(names...))
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return tx.Create(&Permission{UserID: userID, Permission: LevelFromRoles(names...)}).Error
+		}
+		return nil
+	})
+}
+
+// TrashUserRoles moves every role of the user aside for when the user is
+// deleted
+func (r *RoleModel) TrashUserRoles(userID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		names := []string{}
+		if err := tx.Model(&UserRole{}).Where("user_id = ?", userID).Pluck("role_name", &names).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&TrashedUserRole{}).Error; err != nil {
+			return err
+		}
+		if len(names) > 0 {
+			trashed := []TrashedUserRole{}
+			for _, name := range names {
+				trashed = append(trashed, TrashedUserRole{UserID: userID, RoleName: name})
+			}
+			if err := tx.Create(&trashed).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("user_id = ?", userID).Delete(&UserRole{}).Error
+	})
+}
+
+// RestoreUserRoles gives a restored user back the roles set aside by
+// TrashUserRoles, skipping roles deleted since. It reports whether any roles
+// had been set aside, users deleted before roles were kept have none.
+func (r *RoleModel) RestoreUserRoles(userID uuid.UUID) (bool, error) {
+	found := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		trashed := []TrashedUserRole{}
+		if err := tx.Where("user_id = ?", userID).Find(&trashed).Error; err != nil {
+			return err
+		}
+		if len(trashed) == 0 {
+			return nil
+		}
+		found = true
+
+		names := []string{}
+		err := tx.Model(&Role{}).
+			Where("name IN (?)", tx.Model(&TrashedUserRole{}).Select("role_name").Where("user_id = ?", userID)).
+			Pluck("name", &names).
+			Error
+		if err != nil {
+			return err
+		}
+		if err := NewRoleModel(tx).AssignRoles(userID, names...); err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&TrashedUserRole{}).Error
+	})
+	return found, err
+}
+
+// SyncLevelRoles makes the roles that mirror permission bits match the given
+// level, other roles are untouched
+func (r *RoleModel) SyncLevelRoles(userID uuid.UUID, level Level) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		model := NewRoleModel(tx)
+		mirrored := (Read | Write | Approve | Moderate).Roles()
+		if err := model.RemoveRoles(userID, mirrored...); err != nil {
+			return err
+		}
+		return model.AssignRoles(userID, level.Roles()...)
+	})
+}
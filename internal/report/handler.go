@@ -0,0 +1,358 @@
+package report
+
+import (
+	"errors"
+	"fmt"
+	"maribooru/internal/account"
+	"maribooru/internal/audit"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"maribooru/internal/notification"
+	"maribooru/internal/tag"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type (
+	Create struct {
+		TargetType string    `json:"target_type" validate:"required,oneof=tag user"`
+		TargetID   uuid.UUID `json:"target_id" validate:"required"`
+		Category   string    `json:"category" validate:"required,oneof=spam abuse copyright inappropriate other"`
+		Reason     string    `json:"reason" validate:"max=2000"`
+	}
+
+	Close struct {
+		Resolution string `json:"resolution" validate:"max=2000"`
+	}
+
+	Bulk struct {
+		IDs        []uuid.UUID `json:"ids" validate:"required,min=1,max=100"`
+		Action     string      `json:"action" validate:"required,oneof=claim resolve dismiss"`
+		Resolution string      `json:"resolution" validate:"max=2000"`
+	}
+
+	Params struct {
+		helpers.GenericPagedQuery
+		Status      string    `query:"status"`
+		TargetType  string    `query:"target_type"`
+		TargetID    uuid.UUID `query:"target_id"`
+		ReporterID  uuid.UUID `query:"reporter_id"`
+		ClaimedByID uuid.UUID `query:"claimed_by_id"`
+	}
+
+	Response struct {
+		ID         uuid.UUID            `json:"id"`
+		Reporter   account.UserResponse `json:"reporter"`
+		TargetType string               `json:"target_type"`
+		TargetID   uuid.UUID            `json:"target_id"`
+		Category   string               `json:"category"`
+		Reason     string               `json:"reason"`
+		Status     string               `json:"status"`
+		ClaimedBy  account.UserResponse `json:"claimed_by"`
+		ClosedBy   account.UserResponse `json:"closed_by"`
+		Resolution string               `json:"resolution"`
+		ClosedAt   time.Time            `json:"closed_at"`
+		CreatedAt  time.Time            `json:"created_at"`
+	}
+
+	BulkResponse struct {
+		Affected int64 `json:"affected"`
+	}
+
+	Handler struct {
+		db    *gorm.DB
+		model *Model
+		cfg   *config.Config
+		log   *zap.Logger
+	}
+)
+
+func (r *Create) ToTable() Report {
+	return Report{
+		TargetType: r.TargetType,
+		TargetID:   r.TargetID,
+		Category:   r.Category,
+		Reason:     r.Reason,
+		Status:     StatusOpen,
+	}
+}
+
+func (r *Report) ToResponse() Response {
+	return Response{
+		ID:         r.ID,
+		Reporter:   r.Reporter.ToResponse(false),
+		TargetType: r.TargetType,
+		TargetID:   r.TargetID,
+		Category:   r.Category,
+		Reason:     r.Reason,
+		Status:     r.Status,
+		ClaimedBy:  r.ClaimedBy.ToResponse(false),
+		ClosedBy:   r.ClosedBy.ToResponse(false),
+		Resolution: r.Resolution,
+		ClosedAt:   r.ClosedAt,
+		CreatedAt:  r.CreatedAt,
+	}
+}
+
+func (r ReportSlice) ToResponse() []Response {
+	response := make([]Response, 0)
+	for _, report := range r {
+		response = append(response, report.ToResponse())
+	}
+	return response
+}
+
+func NewHandler(db *gorm.DB, cfg *config.Config, log *zap.Logger) *Handler {
+	return &Handler{
+		db:    db,
+		model: NewModel(db),
+		cfg:   cfg,
+		log:   log,
+	}
+}
+
+func (r *Handler) Create(c echo.Context) error {
+	r.log.Debug("ReportHandler: Create")
+	userID, err := helpers.GetUserID(c, r.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	var request Create
+	if err := c.Bind(&request); err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "Invalid request")
+	}
+	if err := c.Validate(&request); err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
+	}
+
+	if request.TargetType == TargetUser && request.TargetID == userID {
+		return helpers.Response(c, http.StatusBadRequest, nil, "You can't report yourself")
+	}
+
+	if err := r.targetExists(request.TargetType, request.TargetID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Reported "+request.TargetType+" not found")
+		}
+		r.log.Error("Failed to get report target", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to create report")
+	}
+
+	pending, err := r.model.HasPending(userID, request.TargetType, request.TargetID)
+	if err != nil {
+		r.log.Error("Failed to check pending reports", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to create report")
+	}
+	if pending {
+		return helpers.Response(c, http.StatusConflict, nil, "You already reported this")
+	}
+
+	report := request.ToTable()
+	report.ReporterID = userID
+	data, err := r.model.Create(report)
+	if err != nil {
+		r.log.Error("Failed to create report", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to create report")
+	}
+
+	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
+}
+
+func (r *Handler) GetOwn(c echo.Context) error {
+	r.log.Debug("ReportHandler: GetOwn")
+	userID, err := helpers.GetUserID(c, r.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	return r.getAll(c, userID)
+}
+
+func (r *Handler) GetQueue(c echo.Context) error {
+	r.log.Debug("ReportHandler: GetQueue")
+	return r.getAll(c, uuid.Nil)
+}
+
+func (r *Handler) getAll(c echo.Context, reporterID uuid.UUID) error {
+	params := Params{
+		GenericPagedQuery: helpers.GenericPagedQuery{
+			Limit:  50,
+			Offset: 0,
+		},
+	}
+	if err := c.Bind(&params); err != nil {
+		r.log.Error("Failed to set limit and offset, defaulting to 50 limit and 0 offset", zap.Error(err))
+	}
+	if reporterID != uuid.Nil {
+		params.ReporterID = reporterID
+	}
+
+	data, total, err := r.model.GetAll(params)
+	if err != nil {
+		r.log.Error("Failed to get reports", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get reports")
+	}
+
+	paged := helpers.PageData(data.ToResponse(), int(total), params.Offset, params.Limit)
+
+	return helpers.Response(c, http.StatusOK, paged, "")
+}
+
+func (r *Handler) Claim(c echo.Context) error {
+	r.log.Debug("ReportHandler: Claim")
+	return r.single(c, "claim")
+}
+
+func (r *Handler) Resolve(c echo.Context) error {
+	r.log.Debug("ReportHandler: Resolve")
+	return r.single(c, "resolve")
+}
+
+func (r *Handler) Dismiss(c echo.Context) error {
+	r.log.Debug("ReportHandler: Dismiss")
+	return r.single(c, "dismiss")
+}
+
+func (r *Handler) Bulk(c echo.Context) error {
+	r.log.Debug("ReportHandler: Bulk")
+	moderatorID, err := helpers.GetUserID(c, r.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	var request Bulk
+	if err := c.Bind(&request); err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "Invalid request")
+	}
+	if err := c.Validate(&request); err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
+	}
+
+	affected, err := r.apply(c, request.IDs, moderatorID, request.Action, request.Resolution)
+	if err != nil {
+		r.log.Error("Failed to update reports", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to update reports")
+	}
+
+	return helpers.Response(c, http.StatusOK, BulkResponse{Affected: affected}, "")
+}
+
+func (r *Handler) single(c echo.Context, action string) error {
+	moderatorID, err := helpers.GetUserID(c, r.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
+	}
+
+	var request Close
+	if action != "claim" {
+		if err := c.Bind(&request); err != nil {
+			return helpers.Response(c, http.StatusBadRequest, nil, "Invalid request")
+		}
+		if err := c.Validate(&request); err != nil {
+			return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
+		}
+	}
+
+	if _, err := r.model.GetByID(id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Report not found")
+		}
+		r.log.Error("Failed to get report", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to update report")
+	}
+
+	affected, err := r.apply(c, []uuid.UUID{id}, moderatorID, action, request.Resolution)
+	if err != nil {
+		r.log.Error("Failed to update report", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to update report")
+	}
+	if affected == 0 {
+		return helpers.Response(c, http.StatusConflict, nil, "Report is closed or claimed by another moderator")
+	}
+
+	data, err := r.model.GetByID(id)
+	if err != nil {
+		r.log.Error("Failed to get report", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get report")
+	}
+
+	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
+}
+
+// apply runs a queue action and tells the reporters about closed reports
+func (r *Handler) apply(c echo.Context, ids []uuid.UUID, moderatorID uuid.UUID, action, resolution string) (int64, error) {
+	if action == "claim" {
+		return r.model.Claim(ids, moderatorID)
+	}
+
+	status, kind := StatusResolved, notification.KindReportResolved
+	if action == "dismiss" {
+		status, kind = StatusDismissed, notification.KindReportDismissed
+	}
+
+	closed, err := r.model.Close(ids, moderatorID, status, resolution)
+	if err != nil {
+		return 0, err
+	}
+
+	auditAction := audit.ActionReportResolved
+	if status == StatusDismissed {
+		auditAction = audit.ActionReportDismissed
+	}
+	for _, report := range closed {
+		audit.Record(r.db, r.log, c, audit.Log{
+			ActorID:    moderatorID,
+			Action:     auditAction,
+			TargetType: audit.TargetReport,
+			TargetID:   report.ID.String(),
+			Before:     audit.ToJSON(map[string]string{"status": report.Status}),
+			After:      audit.ToJSON(map[string]string{"status": status, "resolution": resolution}),
+		})
+	}
+
+	notifications := []notification.Notification{}
+	for _, report := range closed {
+		// The reporter may have been purged since
+		if report.ReporterID == uuid.Nil {
+			continue
+		}
+		message := fmt.Sprintf("Your report on a %s was %s", report.TargetType, status)
+		if resolution != "" {
+			message += ": " + resolution
+		}
+		notifications = append(notifications, notification.Notification{
+			UserID:     report.ReporterID,
+			Kind:       kind,
+			Message:    message,
+			TargetType: "report",
+			TargetID:   report.ID.String(),
+		})
+	}
+	if err := notification.NewModel(r.db).Create(notifications...); err != nil {
+		r.log.Error("Failed to notify reporters", zap.Error(err))
+	}
+
+	return int64(len(closed)), nil
+}
+
+func (r *Handler) targetExists(targetType string, id uuid.UUID) error {
+	switch targetType {
+	case TargetTag:
+		_, err := tag.NewTagModel(r.db).GetByID(id)
+		return err
+	case TargetUser:
+		_, err := account.NewUserModel(r.db).GetByID(id)
+		return err
+	}
+	return gorm.ErrRecordNotFound
+}
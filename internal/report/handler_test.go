@@ -0,0 +1,128 @@
+package report
+
+import (
+	"encoding/json"
+	"maribooru/internal/account"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"maribooru/internal/notification"
+	"maribooru/internal/permission"
+	"maribooru/internal/tag"
+	"maribooru/internal/validation"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gopkg.in/go-playground/assert.v1"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestReportQueue(t *testing.T) {
+	e := echo.New()
+	e.Validator = validation.NewValidator(validator.New())
+
+	cfg := &config.Config{
+		JWT: config.JWT{Secret: "secret", Keys: helpers.NewHMACKeys("secret")},
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(account.User{}, account.Admin{}, permission.Permission{}, permission.UserRole{}, tag.TagCategory{}, tag.Tag{}, Report{}, notification.Notification{})
+
+	log, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := []*account.User{
+		{Name: "reporter", Password: "-"},
+		{Name: "offender", Password: "-"},
+		{Name: "first-moderator", Password: "-"},
+		{Name: "second-moderator", Password: "-"},
+	}
+	if err := db.Create(users).Error; err != nil {
+		t.Fatal(err)
+	}
+	reporter, offender, first, second := users[0], users[1], users[2], users[3]
+
+	handler := NewHandler(db, cfg, log)
+	call := func(h echo.HandlerFunc, user *account.User, id string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(echo.PUT, "/", strings.NewReader(string(data)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		token, err := helpers.GenerateJWT(user.ID, user.Name, cfg.JWT.Keys, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		if err := h(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	request := Create{TargetType: TargetUser, TargetID: offender.ID, Category: "spam", Reason: "posts ads"}
+	rec := call(handler.Create, reporter, "", request)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, http.StatusConflict, call(handler.Create, reporter, "", request).Code)
+	assert.Equal(t, http.StatusNotFound, call(handler.Create, reporter, "", Create{TargetType: TargetTag, TargetID: uuid.New(), Category: "spam"}).Code)
+
+	created := struct {
+		Data Response `json:"data"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	id := created.Data.ID.String()
+
+	assert.Equal(t, http.StatusOK, call(handler.Claim, first, id, nil).Code)
+	assert.Equal(t, http.StatusConflict, call(handler.Resolve, second, id, Close{Resolution: "mine now"}).Code)
+	assert.Equal(t, http.StatusOK, call(handler.Resolve, first, id, Close{Resolution: "user warned"}).Code)
+
+	report, err := NewModel(db).GetByID(created.Data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, StatusResolved, report.Status)
+	assert.Equal(t, first.ID, report.ClosedByID)
+
+	// A report that's already closed can't be closed again, so the reporter
+	// isn't notified twice
+	again, err := NewModel(db).Close([]uuid.UUID{report.ID}, first.ID, StatusDismissed, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(again))
+
+	// A second report is dismissed in bulk alongside the closed one
+	another := Create{TargetType: TargetUser, TargetID: second.ID, Category: "other"}
+	call(handler.Create, reporter, "", another)
+	pending, _, err := NewModel(db).GetAll(Params{GenericPagedQuery: helpers.GenericPagedQuery{Limit: 10}, Status: StatusOpen})
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("GetAll(open) = %d reports, %v, want 1", len(pending), err)
+	}
+	rec = call(handler.Bulk, first, "", Bulk{IDs: []uuid.UUID{report.ID, pending[0].ID}, Action: "dismiss"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, true, strings.Contains(rec.Body.String(), `"affected":1`))
+
+	notifications, total, err := notification.NewModel(db).GetAll(reporter.ID, notification.Params{GenericPagedQuery: helpers.GenericPagedQuery{Limit: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(2), total)
+	assert.Equal(t, notification.KindReportDismissed, notifications[0].Kind)
+	assert.Equal(t, notification.KindReportResolved, notifications[1].Kind)
+}
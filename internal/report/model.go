@@ -0,0 +1,186 @@
+package report
+
+import (
+	"maribooru/internal/account"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type (
+	Report struct {
+		ID          uuid.UUID    `gorm:"primary_key;type:uuid"`
+		ReporterID  uuid.UUID    `gorm:"type:uuid;default:null;index"`
+		Reporter    account.User `gorm:"foreignKey:ReporterID"`
+		TargetType  string       `gorm:"not null;index:idx_report_target"`
+		TargetID    uuid.UUID    `gorm:"type:uuid;not null;index:idx_report_target"`
+		Category    string       `gorm:"not null"`
+		Reason      string       `gorm:"type:text"`
+		Status      string       `gorm:"not null;default:open;index"`
+		ClaimedByID uuid.UUID    `gorm:"type:uuid;default:null"`
+		ClaimedBy   account.User `gorm:"foreignKey:ClaimedByID"`
+		ClosedByID  uuid.UUID    `gorm:"type:uuid;default:null"`
+		ClosedBy    account.User `gorm:"foreignKey:ClosedByID"`
+		Resolution  string       `gorm:"type:text"`
+		ClosedAt    time.Time    `gorm:"default:null"`
+		CreatedAt   time.Time
+		UpdatedAt   time.Time
+	}
+
+	ReportSlice []Report
+
+	Model struct {
+		db *gorm.DB
+	}
+)
+
+const (
+	TargetTag  = "tag"
+	TargetUser = "user"
+)
+
+const (
+	StatusOpen      = "open"
+	StatusClaimed   = "claimed"
+	StatusResolved  = "resolved"
+	StatusDismissed = "dismissed"
+)
+
+func (r *Report) BeforeCreate(tx *gorm.DB) error {
+	r.ID = uuid.New()
+	return nil
+}
+
+func NewModel(db *gorm.DB) *Model {
+	return &Model{
+		db: db,
+	}
+}
+
+func (m *Model) baseSelect() *gorm.DB {
+	return m.db.
+		Model(&Report{}).
+		Preload("Reporter").
+		Preload("ClaimedBy").
+		Preload("ClosedBy")
+}
+
+func (m *Model) Create(report Report) (Report, error) {
+	err := m.db.Create(&report).Clauses(clause.Returning{}).Error
+	if err != nil {
+		return Report{}, err
+	}
+	return m.GetByID(report.ID)
+}
+
+// HasPending reports whether the reporter already has a report on the target
+// that hasn't been closed yet
+func (m *Model) HasPending(reporterID uuid.UUID, targetType string, targetID uuid.UUID) (bool, error) {
+	count := int64(0)
+	err := m.db.Model(&Report{}).
+		Where("reporter_id = ? AND target_type = ? AND target_id = ?", reporterID, targetType, targetID).
+		Where("status IN ?", []string{StatusOpen, StatusClaimed}).
+		Count(&count).
+		Error
+	return count > 0, err
+}
+
+func (m *Model) GetByID(id uuid.UUID) (Report, error) {
+	report := Report{}
+	err := m.baseSelect().
+		Where("id = ?", id).
+		First(&report).
+		Error
+	return report, err
+}
+
+func (m *Model) GetAll(params Params) (ReportSlice, int64, error) {
+	reports := ReportSlice{}
+	tx := m.baseSelect()
+
+	if params.Status != "" {
+		tx = tx.Where("status = ?", params.Status)
+	}
+	if params.TargetType != "" {
+		tx = tx.Where("target_type = ?", params.TargetType)
+	}
+	if params.TargetID != uuid.Nil {
+		tx = tx.Where("target_id = ?", params.TargetID)
+	}
+	if params.ReporterID != uuid.Nil {
+		tx = tx.Where("reporter_id = ?", params.ReporterID)
+	}
+	if params.ClaimedByID != uuid.Nil {
+		tx = tx.Where("claimed_by_id = ?", params.ClaimedByID)
+	}
+
+	total := int64(0)
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	// Oldest first, the queue is worked through in order
+	err := tx.Order("created_at asc").
+		Limit(params.Limit).
+		Offset(params.Offset).
+		Find(&reports).
+		Error
+
+	return reports, total, err
+}
+
+// Claim assigns the open reports among ids to the moderator, returning how
+// many were claimed
+func (m *Model) Claim(ids []uuid.UUID, moderatorID uuid.UUID) (int64, error) {
+	res := m.db.Model(&Report{}).
+		Where("id IN ?", ids).
+		Where("status = ?", StatusOpen).
+		Updates(map[string]interface{}{
+			"status":        StatusClaimed,
+			"claimed_by_id": moderatorID,
+		})
+	return res.RowsAffected, res.Error
+}
+
+// Close resolves or dismisses the reports among ids that are open or claimed
+// by the moderator, returning the reports that were closed. Every update
+// repeats the status check, so when two moderators race on a report only
+// the one whose update lands closes it.
+func (m *Model) Close(ids []uuid.UUID, moderatorID uuid.UUID, status, resolution string) (ReportSlice, error) {
+	closed := ReportSlice{}
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		eligible := func() *gorm.DB {
+			return tx.Model(&Report{}).
+				Where("id IN ?", ids).
+				Where(tx.Where("status = ?", StatusOpen).
+					Or("status = ? AND claimed_by_id = ?", StatusClaimed, moderatorID))
+		}
+
+		candidates := ReportSlice{}
+		if err := eligible().Find(&candidates).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, report := range candidates {
+			res := eligible().
+				Where("id = ?", report.ID).
+				Updates(map[string]interface{}{
+					"status":       status,
+					"closed_by_id": moderatorID,
+					"resolution":   resolution,
+					"closed_at":    now,
+				})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 1 {
+				closed = append(closed, report)
+			}
+		}
+		return nil
+	})
+	return closed, err
+}
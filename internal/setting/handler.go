@@ -1,6 +1,7 @@
 package setting
 
 import (
+	"maribooru/internal/audit"
 	"maribooru/internal/config"
 	"maribooru/internal/helpers"
 	"net/http"
@@ -12,7 +13,13 @@ import (
 
 type (
 	Response struct {
-		AdminCreated bool `json:"admin_created"`
+		AdminCreated     bool   `json:"admin_created"`
+		OIDCEnabled      bool   `json:"oidc_enabled"`
+		RegistrationMode string `json:"registration_mode"`
+	}
+
+	Update struct {
+		RegistrationMode string `json:"registration_mode" validate:"omitempty,oneof=open invite closed approval"`
 	}
 
 	Handler struct {
@@ -24,10 +31,17 @@ type (
 )
 
 func (a AppSettingSlice) ToResponse() Response {
-	response := Response{}
+	response := Response{
+		RegistrationMode: RegistrationOpen,
+	}
 	for _, setting := range a {
-		if setting.Key == "ADMIN_CREATED" {
+		switch setting.Key {
+		case KeyAdminCreated:
 			response.AdminCreated = setting.ValueBool
+		case KeyRegistrationMode:
+			if setting.ValueString != "" {
+				response.RegistrationMode = setting.ValueString
+			}
 		}
 	}
 	return response
@@ -49,5 +63,53 @@ func (s *Handler) Get(c echo.Context) error {
 		s.log.Error("Failed to get settings", zap.Error(err))
 		return helpers.Response(c, http.StatusInternalServerError, nil, "There was an error while getting settings")
 	}
-	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
+	response := data.ToResponse()
+	response.OIDCEnabled = s.cfg.OIDC.Enabled
+
+	return helpers.Response(c, http.StatusOK, response, "")
+}
+
+func (s *Handler) Update(c echo.Context) error {
+	s.log.Debug("Handler: Update")
+
+	var request Update
+	if err := c.Bind(&request); err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "Invalid request")
+	}
+	if err := c.Validate(&request); err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
+	}
+
+	actorID, err := helpers.GetUserID(c, s.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	before, err := s.models.GetRegistrationMode()
+	if err != nil {
+		s.log.Error("Failed to get registration mode", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "There was an error while updating settings")
+	}
+
+	if request.RegistrationMode != "" {
+		err := s.models.Update(AppSetting{
+			Key:         KeyRegistrationMode,
+			ValueString: request.RegistrationMode,
+		})
+		if err != nil {
+			s.log.Error("Failed to update registration mode", zap.Error(err))
+			return helpers.Response(c, http.StatusInternalServerError, nil, "There was an error while updating settings")
+		}
+
+		audit.Record(s.db, s.log, c, audit.Log{
+			ActorID:    actorID,
+			Action:     audit.ActionSettingsUpdated,
+			TargetType: audit.TargetSettings,
+			TargetID:   KeyRegistrationMode,
+			Before:     audit.ToJSON(Update{RegistrationMode: before}),
+			After:      audit.ToJSON(request),
+		})
+	}
+
+	return s.Get(c)
 }
@@ -1,6 +1,10 @@
 package setting
 
-import "gorm.io/gorm"
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
 
 type (
 	AppSetting struct {
@@ -19,6 +23,19 @@ type (
 	}
 )
 
+const (
+	KeyAdminCreated     = "ADMIN_CREATED"
+	KeyRegistrationMode = "REGISTRATION_MODE"
+	KeyRolesMigrated    = "ROLES_MIGRATED"
+)
+
+const (
+	RegistrationOpen     = "open"
+	RegistrationInvite   = "invite"
+	RegistrationClosed   = "closed"
+	RegistrationApproval = "approval"
+)
+
 func NewModel(db *gorm.DB) *Model {
 	return &Model{
 		db: db,
@@ -44,3 +61,19 @@ func (s *Model) Update(settings AppSetting) error {
 	}
 	return nil
 }
+
+// GetRegistrationMode falls back to open registration when the setting was
+// never stored
+func (s *Model) GetRegistrationMode() (string, error) {
+	settings, err := s.GetByKey(KeyRegistrationMode)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return RegistrationOpen, nil
+		}
+		return "", err
+	}
+	if settings.ValueString == "" {
+		return RegistrationOpen, nil
+	}
+	return settings.ValueString, nil
+}
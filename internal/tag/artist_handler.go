@@ -0,0 +1,300 @@
+package tag
+
+import (
+	"errors"
+	"maribooru/internal/account"
+	"maribooru/internal/audit"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type (
+	ArtistRequest struct {
+		TagID      uuid.UUID `json:"tag_id" validate:"required"`
+		GroupID    uuid.UUID `json:"group_id"`
+		OtherNames []string  `json:"other_names" validate:"max=50,dive,max=255"`
+		URLs       []string  `json:"urls" validate:"max=50,dive,max=2048"`
+	}
+
+	ArtistParams struct {
+		helpers.GenericPagedQuery
+		GroupID uuid.UUID `query:"group_id"`
+	}
+
+	ArtistURLResponse struct {
+		URL        string `json:"url"`
+		Normalized string `json:"normalized"`
+	}
+
+	ArtistResponse struct {
+		TagID      uuid.UUID            `json:"tag_id"`
+		Slug       string               `json:"slug"`
+		Name       string               `json:"name"`
+		GroupID    uuid.UUID            `json:"group_id"`
+		GroupSlug  string               `json:"group_slug"`
+		OtherNames []string             `json:"other_names"`
+		URLs       []ArtistURLResponse  `json:"urls"`
+		UpdatedAt  time.Time            `json:"updated_at"`
+		UpdatedBy  account.UserResponse `json:"updated_by"`
+	}
+
+	ArtistHandler struct {
+		db    *gorm.DB
+		model *ArtistModel
+		cfg   *config.Config
+		log   *zap.Logger
+	}
+)
+
+// ToTable builds the artist record, dropping blank and repeated names and
+// URLs that normalize to the same address
+func (a *ArtistRequest) ToTable() (Artist, error) {
+	artist := Artist{
+		TagID:   a.TagID,
+		GroupID: a.GroupID,
+	}
+
+	names := map[string]bool{}
+	for _, name := range a.OtherNames {
+		name = strings.TrimSpace(name)
+		if name == "" || names[strings.ToLower(name)] {
+			continue
+		}
+		names[strings.ToLower(name)] = true
+		artist.OtherNames = append(artist.OtherNames, ArtistName{ArtistID: a.TagID, Name: name})
+	}
+
+	urls := map[string]bool{}
+	for _, raw := range a.URLs {
+		normalized, err := helpers.NormalizeURL(raw)
+		if err != nil {
+			return Artist{}, errors.New("Invalid URL " + raw)
+		}
+		if urls[normalized] {
+			continue
+		}
+		urls[normalized] = true
+		artist.URLs = append(artist.URLs, ArtistURL{ArtistID: a.TagID, URL: strings.TrimSpace(raw), Normalized: normalized})
+	}
+
+	return artist, nil
+}
+
+func (a *Artist) ToResponse() ArtistResponse {
+	response := ArtistResponse{
+		TagID:      a.TagID,
+		Slug:       a.Tag.Slug,
+		Name:       a.Tag.Name,
+		GroupID:    a.GroupID,
+		GroupSlug:  a.Group.Slug,
+		OtherNames: make([]string, 0),
+		URLs:       make([]ArtistURLResponse, 0),
+		UpdatedAt:  a.UpdatedAt,
+		UpdatedBy:  a.UpdatedBy.ToResponse(false),
+	}
+	for _, name := range a.OtherNames {
+		response.OtherNames = append(response.OtherNames, name.Name)
+	}
+	for _, url := range a.URLs {
+		response.URLs = append(response.URLs, ArtistURLResponse{URL: url.URL, Normalized: url.Normalized})
+	}
+	return response
+}
+
+func (a ArtistSlice) ToResponse() []ArtistResponse {
+	response := make([]ArtistResponse, 0)
+	for _, artist := range a {
+		response = append(response, artist.ToResponse())
+	}
+	return response
+}
+
+func NewArtistHandler(db *gorm.DB, cfg *config.Config, log *zap.Logger) *ArtistHandler {
+	return &ArtistHandler{
+		db:    db,
+		model: NewArtistModel(db),
+		cfg:   cfg,
+		log:   log,
+	}
+}
+
+func (a *ArtistHandler) GetAll(c echo.Context) error {
+	a.log.Debug("ArtistHandler: GetAll")
+	params := ArtistParams{
+		GenericPagedQuery: helpers.GenericPagedQuery{
+			Limit:  50,
+			Offset: 0,
+		},
+	}
+	if err := c.Bind(&params); err != nil {
+		a.log.Error("Failed to set limit and offset, defaulting to 50 limit and 0 offset", zap.Error(err))
+	}
+
+	data, total, err := a.model.GetAll(params)
+	if err != nil {
+		a.log.Error("Failed to get artists", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get artists")
+	}
+
+	paged := helpers.PageData(data.ToResponse(), int(total), params.Offset, params.Limit)
+
+	return helpers.Response(c, http.StatusOK, paged, "")
+}
+
+func (a *ArtistHandler) GetByID(c echo.Context) error {
+	a.log.Debug("ArtistHandler: GetByID")
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
+	}
+
+	data, err := a.model.GetByTagID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Artist not found")
+		}
+		a.log.Error("Failed to get artist", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get artist")
+	}
+	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
+}
+
+// Lookup finds the artist of a source URL so uploaders can fill in the
+// artist tag
+func (a *ArtistHandler) Lookup(c echo.Context) error {
+	a.log.Debug("ArtistHandler: Lookup")
+	raw := c.QueryParam("url")
+	if raw == "" {
+		return helpers.Response(c, http.StatusBadRequest, nil, "URL is needed")
+	}
+
+	data, err := a.model.GetByURL(raw)
+	if err != nil {
+		if errors.Is(err, helpers.ErrInvalidURL) {
+			return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
+		}
+		a.log.Error("Failed to look up artist", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to look up artist")
+	}
+	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
+}
+
+func (a *ArtistHandler) Save(c echo.Context) error {
+	a.log.Debug("ArtistHandler: Save")
+	userID, err := helpers.GetUserID(c, a.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	var request ArtistRequest
+	if err := c.Bind(&request); err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "Invalid request")
+	}
+	if err := c.Validate(&request); err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
+	}
+
+	artist, err := request.ToTable()
+	if err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
+	}
+	artist.UpdatedByID = userID
+
+	if err := a.model.ensureArtistTag(artist.TagID); err != nil {
+		return a.artistTagError(c, err, "Tag not found")
+	}
+	if artist.GroupID != uuid.Nil {
+		if artist.GroupID == artist.TagID {
+			return helpers.Response(c, http.StatusBadRequest, nil, "An artist can't be its own group")
+		}
+		if err := a.model.ensureArtistTag(artist.GroupID); err != nil {
+			return a.artistTagError(c, err, "Group not found")
+		}
+	}
+
+	before, err := a.model.GetByTagID(artist.TagID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		a.log.Error("Failed to get artist", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to save artist")
+	}
+	existed := err == nil
+
+	data, err := a.model.Save(artist)
+	if err != nil {
+		a.log.Error("Failed to save artist", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to save artist")
+	}
+
+	entry := audit.Log{
+		ActorID:    userID,
+		Action:     audit.ActionArtistUpdated,
+		TargetType: audit.TargetArtist,
+		TargetID:   data.TagID.String(),
+		After:      audit.ToJSON(data.ToResponse()),
+	}
+	if existed {
+		entry.Before = audit.ToJSON(before.ToResponse())
+	}
+	audit.Record(a.db, a.log, c, entry)
+
+	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
+}
+
+func (a *ArtistHandler) Delete(c echo.Context) error {
+	a.log.Debug("ArtistHandler: Delete")
+	userID, err := helpers.GetUserID(c, a.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
+	}
+
+	before, err := a.model.GetByTagID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Artist not found")
+		}
+		a.log.Error("Failed to get artist", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to delete artist")
+	}
+
+	if err := a.model.Delete(id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Artist not found")
+		}
+		a.log.Error("Failed to delete artist", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to delete artist")
+	}
+
+	audit.Record(a.db, a.log, c, audit.Log{
+		ActorID:    userID,
+		Action:     audit.ActionArtistDeleted,
+		TargetType: audit.TargetArtist,
+		TargetID:   id.String(),
+		Before:     audit.ToJSON(before.ToResponse()),
+	})
+
+	return helpers.Response(c, http.StatusOK, nil, "")
+}
+
+func (a *ArtistHandler) artistTagError(c echo.Context, err error, notFound string) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return helpers.Response(c, http.StatusNotFound, nil, notFound)
+	case errors.Is(err, ErrNotArtist):
+		return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
+	}
+	a.log.Error("Failed to get tag", zap.Error(err))
+	return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to save artist")
+}
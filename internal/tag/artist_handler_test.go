@@ -0,0 +1,140 @@
+package tag
+
+import (
+	"encoding/json"
+	"maribooru/internal/account"
+	"maribooru/internal/audit"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"maribooru/internal/validation"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gopkg.in/go-playground/assert.v1"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestArtists(t *testing.T) {
+	e := echo.New()
+	e.Validator = validation.NewValidator(validator.New())
+
+	cfg := &config.Config{
+		JWT: config.JWT{Secret: "secret", Keys: helpers.NewHMACKeys("secret")},
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(account.User{}, TagCategory{}, Tag{}, Artist{}, ArtistName{}, ArtistURL{}, audit.Log{})
+
+	log, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := account.User{Name: "artist-editor", Password: "-"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	artistCategory, err := NewCategoryModel(db).Create(TagCategory{Slug: ArtistCategorySlug, Name: "Artist"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	generalCategory, err := NewCategoryModel(db).Create(TagCategory{Slug: "artist-test-general", Name: "General"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTag := func(slug string, category TagCategory) Tag {
+		created, err := NewTagModel(db).Create(Tag{Slug: slug, CategoryID: category.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return created
+	}
+	circle := newTag("some_circle", artistCategory)
+	painter := newTag("some_painter", artistCategory)
+	landscape := newTag("landscape", generalCategory)
+
+	handler := NewArtistHandler(db, cfg, log)
+	save := func(request ArtistRequest) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(request)
+		req := httptest.NewRequest(echo.PUT, "/", strings.NewReader(string(data)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		token, err := helpers.GenerateJWT(user.ID, user.Name, cfg.JWT.Keys, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		if err := handler.Save(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+	lookup := func(source string) []ArtistResponse {
+		req := httptest.NewRequest(echo.GET, "/?"+url.Values{"url": {source}}.Encode(), nil)
+		rec := httptest.NewRecorder()
+		if err := handler.Lookup(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		body := struct {
+			Data []ArtistResponse `json:"data"`
+		}{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body.Data
+	}
+
+	assert.Equal(t, http.StatusBadRequest, save(ArtistRequest{TagID: landscape.ID}).Code)
+	assert.Equal(t, http.StatusBadRequest, save(ArtistRequest{TagID: painter.ID, GroupID: landscape.ID}).Code)
+	assert.Equal(t, http.StatusBadRequest, save(ArtistRequest{TagID: painter.ID, URLs: []string{"ftp://example.com"}}).Code)
+
+	assert.Equal(t, http.StatusOK, save(ArtistRequest{TagID: circle.ID, URLs: []string{"https://circle.example.com"}}).Code)
+	rec := save(ArtistRequest{
+		TagID:      painter.ID,
+		GroupID:    circle.ID,
+		OtherNames: []string{"Painter", "painter", " "},
+		URLs:       []string{"https://x.com/painter", "https://twitter.com/painter/", "https://www.pixiv.net/users/42"},
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	saved := struct {
+		Data ArtistResponse `json:"data"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &saved); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "some_circle", saved.Data.GroupSlug)
+	assert.Equal(t, 1, len(saved.Data.OtherNames))
+	assert.Equal(t, 2, len(saved.Data.URLs))
+
+	// A link to a single work resolves to the profile it's posted under
+	found := lookup("https://mobile.twitter.com/painter/status/123?s=20")
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "some_painter", found[0].Slug)
+	assert.Equal(t, 1, len(lookup("pixiv.net/users/42/artworks")))
+	assert.Equal(t, 0, len(lookup("https://twitter.com/someone_else")))
+
+	// Saving again replaces the URLs
+	assert.Equal(t, http.StatusOK, save(ArtistRequest{TagID: painter.ID, URLs: []string{"https://painter.example.com"}}).Code)
+	assert.Equal(t, 0, len(lookup("https://twitter.com/painter")))
+
+	// Profiles that live on their own subdomain are found from their posts
+	found = lookup("https://painter.example.com/posts/7")
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "some_painter", found[0].Slug)
+	assert.Equal(t, 0, len(lookup("https://example.com/painter")))
+}
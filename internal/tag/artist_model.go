@@ -0,0 +1,213 @@
+package tag
+
+import (
+	"errors"
+	"fmt"
+	"maribooru/internal/account"
+	"maribooru/internal/helpers"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type (
+	// Artist holds the metadata of a tag in the artist category, it shares
+	// the ID of its tag
+	Artist struct {
+		TagID       uuid.UUID    `gorm:"primary_key;type:uuid"`
+		Tag         Tag          `gorm:"foreignKey:TagID"`
+		GroupID     uuid.UUID    `gorm:"type:uuid;default:null;index"`
+		Group       Tag          `gorm:"foreignKey:GroupID"`
+		OtherNames  []ArtistName `gorm:"foreignKey:ArtistID"`
+		URLs        []ArtistURL  `gorm:"foreignKey:ArtistID"`
+		UpdatedByID uuid.UUID    `gorm:"type:uuid;default:null"`
+		UpdatedBy   account.User `gorm:"foreignKey:UpdatedByID"`
+		CreatedAt   time.Time
+		UpdatedAt   time.Time
+	}
+
+	ArtistName struct {
+		ArtistID uuid.UUID `gorm:"type:uuid;primary_key"`
+		Name     string    `gorm:"type:varchar(255);primary_key;index"`
+	}
+
+	ArtistURL struct {
+		ArtistID uuid.UUID `gorm:"type:uuid;primary_key"`
+		URL      string    `gorm:"type:varchar(2048);not null"`
+		// Normalized is what lookups match against, see helpers.NormalizeURL
+		Normalized string `gorm:"type:varchar(2048);primary_key;index"`
+	}
+
+	ArtistSlice []Artist
+
+	ArtistModel struct {
+		db *gorm.DB
+	}
+)
+
+// ArtistCategorySlug is the tag category whose tags can have artist records
+const ArtistCategorySlug = "artist"
+
+var ErrNotArtist = errors.New("Tag is not in the artist category")
+
+func NewArtistModel(db *gorm.DB) *ArtistModel {
+	return &ArtistModel{
+		db: db,
+	}
+}
+
+func (m *ArtistModel) baseSelect() *gorm.DB {
+	return m.db.
+		Model(&Artist{}).
+		Preload("Tag").
+		Preload("Group").
+		Preload("OtherNames").
+		Preload("URLs").
+		Preload("UpdatedBy")
+}
+
+func (m *ArtistModel) GetByTagID(id uuid.UUID) (Artist, error) {
+	artist := Artist{}
+	err := m.baseSelect().
+		Where("tag_id = ?", id).
+		First(&artist).
+		Error
+	return artist, err
+}
+
+func (m *ArtistModel) GetAll(params ArtistParams) (ArtistSlice, int64, error) {
+	artists := ArtistSlice{}
+	keywords := fmt.Sprintf("%%%s%%", params.Keywords)
+
+	tx := m.baseSelect().
+		Joins("JOIN tags ON tags.id = artists.tag_id AND tags.deleted_at IS NULL").
+		Where("tags.slug ilike ? OR EXISTS (SELECT 1 FROM artist_names WHERE artist_names.artist_id = artists.tag_id AND artist_names.name ilike ?)", keywords, keywords)
+
+	if params.GroupID != uuid.Nil {
+		tx = tx.Where("artists.group_id = ?", params.GroupID)
+	}
+
+	total := int64(0)
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := tx.Order("tags.slug").
+		Limit(params.Limit).
+		Offset(params.Offset).
+		Find(&artists).
+		Error
+
+	return artists, total, err
+}
+
+// GetByURL finds the artists owning the longest profile URL that the given
+// URL starts with, so a link to a single work resolves to its artist
+func (m *ArtistModel) GetByURL(raw string) (ArtistSlice, error) {
+	normalized, err := helpers.NormalizeURL(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, prefix := range helpers.URLPrefixes(normalized) {
+		ids := []uuid.UUID{}
+		err := m.db.Model(&ArtistURL{}).
+			Joins("JOIN tags ON tags.id = artist_urls.artist_id AND tags.deleted_at IS NULL").
+			Where("artist_urls.normalized = ?", prefix).
+			Distinct().
+			Pluck("artist_urls.artist_id", &ids).
+			Error
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		artists := ArtistSlice{}
+		err = m.baseSelect().Where("tag_id IN ?", ids).Find(&artists).Error
+		return artists, err
+	}
+
+	return ArtistSlice{}, nil
+}
+
+// Save creates or replaces the artist record of a tag, other names and URLs
+// are replaced as a whole
+func (m *ArtistModel) Save(artist Artist) (Artist, error) {
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "tag_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"group_id", "updated_by_id", "updated_at"}),
+		}).Create(&artist).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("artist_id = ?", artist.TagID).Delete(&ArtistName{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("artist_id = ?", artist.TagID).Delete(&ArtistURL{}).Error; err != nil {
+			return err
+		}
+
+		if len(artist.OtherNames) > 0 {
+			if err := tx.Create(&artist.OtherNames).Error; err != nil {
+				return err
+			}
+		}
+		if len(artist.URLs) > 0 {
+			if err := tx.Create(&artist.URLs).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Artist{}, err
+	}
+	return m.GetByTagID(artist.TagID)
+}
+
+// Delete removes the artist record of a tag, members of a deleted group are
+// left without a group
+func (m *ArtistModel) Delete(id uuid.UUID) error {
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		return DeleteArtist(tx, id)
+	})
+}
+
+// DeleteArtist removes the artist record of a tag inside an existing
+// transaction, it's shared with purging tags for good
+func DeleteArtist(tx *gorm.DB, id uuid.UUID) error {
+	if err := tx.Model(&Artist{}).Where("group_id = ?", id).Update("group_id", nil).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("artist_id = ?", id).Delete(&ArtistName{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("artist_id = ?", id).Delete(&ArtistURL{}).Error; err != nil {
+		return err
+	}
+	res := tx.Where("tag_id = ?", id).Delete(&Artist{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ensureArtistTag fails unless the tag belongs to the artist category
+func (m *ArtistModel) ensureArtistTag(id uuid.UUID) error {
+	tag, err := NewTagModel(m.db).GetByID(id)
+	if err != nil {
+		return err
+	}
+	if tag.Category.Slug != ArtistCategorySlug {
+		return ErrNotArtist
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package tag
 import (
 	"errors"
 	"maribooru/internal/account"
+	"maribooru/internal/audit"
 	"maribooru/internal/config"
 	"maribooru/internal/helpers"
 	"net/http"
@@ -95,7 +96,7 @@ func NewCategoryHandler(db *gorm.DB, cfg *config.Config, log *zap.Logger) *Categ
 
 func (ch *CategoryHandler) CreateCategory(c echo.Context) error {
 	ch.log.Debug("CategoryHandler: Create")
-	userID, err := helpers.GetUserID(c, ch.cfg.JWT.Secret)
+	userID, err := helpers.GetUserID(c, ch.cfg.JWT.Keys)
 	if err != nil {
 		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
 	}
@@ -120,6 +121,14 @@ func (ch *CategoryHandler) CreateCategory(c echo.Context) error {
 		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to create tag category")
 	}
 
+	audit.Record(ch.db, ch.log, c, audit.Log{
+		ActorID:    userID,
+		Action:     audit.ActionCategoryCreated,
+		TargetType: audit.TargetCategory,
+		TargetID:   data.ID.String(),
+		After:      audit.ToJSON(data.ToResponse()),
+	})
+
 	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
 }
 
@@ -167,7 +176,7 @@ func (ch *CategoryHandler) GetCategories(c echo.Context) error {
 
 func (ch *CategoryHandler) UpdateCategory(c echo.Context) error {
 	ch.log.Debug("CategoryHandler: Update")
-	userID, err := helpers.GetUserID(c, ch.cfg.JWT.Secret)
+	userID, err := helpers.GetUserID(c, ch.cfg.JWT.Keys)
 	if err != nil {
 		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
 	}
@@ -180,6 +189,15 @@ func (ch *CategoryHandler) UpdateCategory(c echo.Context) error {
 		return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
 	}
 
+	before, err := ch.model.GetByID(request.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Tag category not found")
+		}
+		ch.log.Error("Failed to get tag category", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to update tag category")
+	}
+
 	category := request.ToTable()
 	category.UpdatedByID = userID
 
@@ -192,12 +210,21 @@ func (ch *CategoryHandler) UpdateCategory(c echo.Context) error {
 		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to update tag category")
 	}
 
+	audit.Record(ch.db, ch.log, c, audit.Log{
+		ActorID:    userID,
+		Action:     audit.ActionCategoryUpdated,
+		TargetType: audit.TargetCategory,
+		TargetID:   data.ID.String(),
+		Before:     audit.ToJSON(before.ToResponse()),
+		After:      audit.ToJSON(data.ToResponse()),
+	})
+
 	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
 }
 
 func (ch *CategoryHandler) DeleteCategory(c echo.Context) error {
 	ch.log.Debug("CategoryHandler: Delete")
-	userID, err := helpers.GetUserID(c, ch.cfg.JWT.Secret)
+	userID, err := helpers.GetUserID(c, ch.cfg.JWT.Keys)
 	if err != nil {
 		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
 	}
@@ -206,6 +233,16 @@ func (ch *CategoryHandler) DeleteCategory(c echo.Context) error {
 	if err != nil {
 		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
 	}
+
+	before, err := ch.model.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Tag category not found")
+		}
+		ch.log.Error("Failed to get tag category", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to delete tag category")
+	}
+
 	if err := ch.model.Delete(id, userID); err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return helpers.Response(c, http.StatusNotFound, nil, "Tag category not found")
@@ -214,5 +251,13 @@ func (ch *CategoryHandler) DeleteCategory(c echo.Context) error {
 		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to delete tag category")
 	}
 
+	audit.Record(ch.db, ch.log, c, audit.Log{
+		ActorID:    userID,
+		Action:     audit.ActionCategoryDeleted,
+		TargetType: audit.TargetCategory,
+		TargetID:   id.String(),
+		Before:     audit.ToJSON(before.ToResponse()),
+	})
+
 	return helpers.Response(c, http.StatusOK, nil, "")
 }
@@ -2,6 +2,7 @@ package tag
 
 import (
 	"errors"
+	"maribooru/internal/audit"
 	"maribooru/internal/config"
 	"maribooru/internal/helpers"
 	"net/http"
@@ -96,7 +97,7 @@ func NewTagHandler(db *gorm.DB, cfg *config.Config, log *zap.Logger) *TagHandler
 
 func (t *TagHandler) Create(c echo.Context) error {
 	t.log.Debug("TagHandler: Create")
-	userID, err := helpers.GetUserID(c, t.cfg.JWT.Secret)
+	userID, err := helpers.GetUserID(c, t.cfg.JWT.Keys)
 	if err != nil {
 		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
 	}
@@ -118,6 +119,14 @@ func (t *TagHandler) Create(c echo.Context) error {
 		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to create tag")
 	}
 
+	audit.Record(t.db, t.log, c, audit.Log{
+		ActorID:    userID,
+		Action:     audit.ActionTagCreated,
+		TargetType: audit.TargetTag,
+		TargetID:   data.ID.String(),
+		After:      audit.ToJSON(data.ToResponse()),
+	})
+
 	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
 }
 
@@ -179,7 +188,7 @@ func (t *TagHandler) GetAll(c echo.Context) error {
 
 func (t *TagHandler) Update(c echo.Context) error {
 	t.log.Debug("TagHandler: Update")
-	userID, err := helpers.GetUserID(c, t.cfg.JWT.Secret)
+	userID, err := helpers.GetUserID(c, t.cfg.JWT.Keys)
 	if err != nil {
 		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
 	}
@@ -192,6 +201,15 @@ func (t *TagHandler) Update(c echo.Context) error {
 		return helpers.Response(c, http.StatusBadRequest, nil, err.Error())
 	}
 
+	before, err := t.model.GetByID(request.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Tag not found")
+		}
+		t.log.Error("Failed to get tag", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to update tag")
+	}
+
 	tag := request.ToTable()
 	tag.UpdatedByID = userID
 
@@ -203,12 +221,22 @@ func (t *TagHandler) Update(c echo.Context) error {
 		t.log.Error("Failed to update tag", zap.Error(err))
 		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to update tag")
 	}
+
+	audit.Record(t.db, t.log, c, audit.Log{
+		ActorID:    userID,
+		Action:     audit.ActionTagUpdated,
+		TargetType: audit.TargetTag,
+		TargetID:   data.ID.String(),
+		Before:     audit.ToJSON(before.ToResponse()),
+		After:      audit.ToJSON(data.ToResponse()),
+	})
+
 	return helpers.Response(c, http.StatusOK, data.ToResponse(), "")
 }
 
 func (t *TagHandler) Delete(c echo.Context) error {
 	t.log.Debug("TagHandler: Delete")
-	userID, err := helpers.GetUserID(c, t.cfg.JWT.Secret)
+	userID, err := helpers.GetUserID(c, t.cfg.JWT.Keys)
 	if err != nil {
 		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
 	}
@@ -217,6 +245,16 @@ func (t *TagHandler) Delete(c echo.Context) error {
 	if err != nil {
 		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
 	}
+
+	before, err := t.model.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return helpers.Response(c, http.StatusNotFound, nil, "Tag not found")
+		}
+		t.log.Error("Failed to get tag", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to delete tag")
+	}
+
 	err = t.model.Delete(id, userID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -225,5 +263,14 @@ func (t *TagHandler) Delete(c echo.Context) error {
 		t.log.Error("Failed to delete tag", zap.Error(err))
 		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to delete tag")
 	}
+
+	audit.Record(t.db, t.log, c, audit.Log{
+		ActorID:    userID,
+		Action:     audit.ActionTagDeleted,
+		TargetType: audit.TargetTag,
+		TargetID:   id.String(),
+		Before:     audit.ToJSON(before.ToResponse()),
+	})
+
 	return helpers.Response(c, http.StatusOK, nil, "")
 }
@@ -0,0 +1,167 @@
+package trash
+
+import (
+	"errors"
+	"maribooru/internal/account"
+	"maribooru/internal/audit"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type (
+	Params struct {
+		helpers.GenericPagedQuery
+	}
+
+	Response struct {
+		ID        uuid.UUID            `json:"id"`
+		Type      string               `json:"type"`
+		Slug      string               `json:"slug,omitempty"`
+		Name      string               `json:"name"`
+		DeletedAt time.Time            `json:"deleted_at"`
+		DeletedBy account.UserResponse `json:"deleted_by"`
+		PurgeAt   time.Time            `json:"purge_at"`
+	}
+
+	Handler struct {
+		db    *gorm.DB
+		model *Model
+		cfg   *config.Config
+		log   *zap.Logger
+	}
+)
+
+var auditTargets = map[string]string{
+	TypeTag:      audit.TargetTag,
+	TypeCategory: audit.TargetCategory,
+	TypeUser:     audit.TargetUser,
+}
+
+func (i *Item) ToResponse(retention time.Duration) Response {
+	response := Response{
+		ID:        i.ID,
+		Type:      i.Type,
+		Slug:      i.Slug,
+		Name:      i.Name,
+		DeletedAt: i.DeletedAt,
+		DeletedBy: i.DeletedBy.ToResponse(false),
+	}
+	if retention > 0 {
+		response.PurgeAt = i.DeletedAt.Add(retention)
+	}
+	return response
+}
+
+func (i ItemSlice) ToResponse(retention time.Duration) []Response {
+	response := make([]Response, 0)
+	for _, item := range i {
+		response = append(response, item.ToResponse(retention))
+	}
+	return response
+}
+
+func NewHandler(db *gorm.DB, cfg *config.Config, log *zap.Logger) *Handler {
+	return &Handler{
+		db:    db,
+		model: NewModel(db),
+		cfg:   cfg,
+		log:   log,
+	}
+}
+
+func (t *Handler) GetAll(c echo.Context) error {
+	t.log.Debug("TrashHandler: GetAll")
+	params := Params{
+		GenericPagedQuery: helpers.GenericPagedQuery{
+			Limit:  50,
+			Offset: 0,
+		},
+	}
+	if err := c.Bind(&params); err != nil {
+		t.log.Error("Failed to set limit and offset, defaulting to 50 limit and 0 offset", zap.Error(err))
+	}
+
+	data, total, err := t.model.GetAll(c.Param("type"), params)
+	if err != nil {
+		if errors.Is(err, ErrUnknownType) {
+			return helpers.Response(c, http.StatusNotFound, nil, err.Error())
+		}
+		t.log.Error("Failed to get trash", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get trash")
+	}
+
+	paged := helpers.PageData(data.ToResponse(t.cfg.Trash.Retention), int(total), params.Offset, params.Limit)
+
+	return helpers.Response(c, http.StatusOK, paged, "")
+}
+
+func (t *Handler) Restore(c echo.Context) error {
+	t.log.Debug("TrashHandler: Restore")
+	return t.single(c, audit.ActionTrashRestored)
+}
+
+func (t *Handler) Purge(c echo.Context) error {
+	t.log.Debug("TrashHandler: Purge")
+	return t.single(c, audit.ActionTrashPurged)
+}
+
+func (t *Handler) single(c echo.Context, action string) error {
+	actorID, err := helpers.GetUserID(c, t.cfg.JWT.Keys)
+	if err != nil {
+		return helpers.Response(c, http.StatusUnauthorized, nil, "Unauthorized")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return helpers.Response(c, http.StatusBadRequest, nil, "ID is needed")
+	}
+
+	item, err := t.model.GetByID(c.Param("type"), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnknownType):
+			return helpers.Response(c, http.StatusNotFound, nil, err.Error())
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return helpers.Response(c, http.StatusNotFound, nil, "Item not found in trash")
+		}
+		t.log.Error("Failed to get trashed item", zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to get item")
+	}
+
+	if action == audit.ActionTrashRestored {
+		err = t.model.Restore(item.Type, item.ID)
+	} else {
+		err = t.model.Purge(item.Type, item.ID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return helpers.Response(c, http.StatusNotFound, nil, "Item not found in trash")
+		case errors.Is(err, ErrParentDeleted):
+			return helpers.Response(c, http.StatusConflict, nil, "Restore the tag category first")
+		case errors.Is(err, gorm.ErrDuplicatedKey):
+			return helpers.Response(c, http.StatusConflict, nil, "An item with the same name already exists")
+		case errors.Is(err, gorm.ErrForeignKeyViolated):
+			return helpers.Response(c, http.StatusConflict, nil, "Item is still referenced")
+		}
+		t.log.Error("Failed to update trashed item", zap.String("action", action), zap.Error(err))
+		return helpers.Response(c, http.StatusInternalServerError, nil, "Failed to update item")
+	}
+
+	audit.Record(t.db, t.log, c, audit.Log{
+		ActorID:    actorID,
+		Action:     action,
+		TargetType: auditTargets[item.Type],
+		TargetID:   item.ID.String(),
+		Before:     audit.ToJSON(item.ToResponse(0)),
+	})
+
+	return helpers.Response(c, http.StatusOK, nil, "")
+}
@@ -0,0 +1,187 @@
+package trash
+
+import (
+	"encoding/json"
+	"maribooru/internal/account"
+	"maribooru/internal/audit"
+	"maribooru/internal/common"
+	"maribooru/internal/config"
+	"maribooru/internal/helpers"
+	"maribooru/internal/notification"
+	"maribooru/internal/oidc"
+	"maribooru/internal/permission"
+	"maribooru/internal/report"
+	"maribooru/internal/tag"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gopkg.in/go-playground/assert.v1"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestTrash(t *testing.T) {
+	e := echo.New()
+
+	cfg := &config.Config{
+		JWT:   config.JWT{Secret: "secret", Keys: helpers.NewHMACKeys("secret")},
+		Trash: config.Trash{Retention: time.Hour},
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(account.User{}, account.Admin{}, account.Invite{}, account.Ban{}, account.LoginAttempt{}, permission.Permission{}, permission.Role{}, permission.RoleCapability{}, permission.UserRole{}, permission.TrashedUserRole{}, tag.TagCategory{}, tag.Tag{}, tag.Artist{}, tag.ArtistName{}, tag.ArtistURL{}, oidc.Identity{}, oidc.AuthRequest{}, report.Report{}, notification.Notification{}, audit.Log{})
+	if err := permission.NewRoleModel(db).SeedBuiltIn(); err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := permission.NewRoleModel(db).Create(permission.Role{Name: "trash-curator"}); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := account.User{Name: "trash-admin", Password: "-"}
+	member := account.User{Name: "trash-member", Password: "-", Roles: permission.NewUserRoles(permission.RoleMember, permission.RoleJanitor, "trash-curator")}
+	if err := db.Create(&admin).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&member).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&permission.Permission{UserID: member.ID, Permission: permission.Read}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	category, err := tag.NewCategoryModel(db).Create(tag.TagCategory{Slug: "trash-general", Name: "General"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := tag.NewTagModel(db).Create(tag.Tag{Slug: "trash-tag", Name: "Tag", CategoryID: category.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tag.NewTagModel(db).Delete(created.ID, admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := tag.NewCategoryModel(db).Delete(category.ID, admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := account.NewUserModel(db).Delete(member.ID); err != nil {
+		t.Fatal(err)
+	}
+	allowed, err := account.HasCapability(db, member.ID, permission.CapRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, false, allowed)
+
+	handler := NewHandler(db, cfg, log)
+	call := func(h echo.HandlerFunc, itemType, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.PUT, "/", nil)
+		token, err := helpers.GenerateJWT(admin.ID, admin.Name, cfg.JWT.Keys, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("type", "id")
+		c.SetParamValues(itemType, id)
+		if err := h(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	rec := call(handler.GetAll, TypeTag, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	listed := struct {
+		Data struct {
+			List []Response `json:"list"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(listed.Data.List))
+	assert.Equal(t, "trash-admin", listed.Data.List[0].DeletedBy.Name)
+	assert.Equal(t, http.StatusNotFound, call(handler.GetAll, "posts", "").Code)
+
+	// The tag can't come back while its category is still in the trash
+	assert.Equal(t, http.StatusConflict, call(handler.Restore, TypeTag, created.ID.String()).Code)
+	assert.Equal(t, http.StatusOK, call(handler.Restore, TypeCategory, category.ID.String()).Code)
+	assert.Equal(t, http.StatusOK, call(handler.Restore, TypeTag, created.ID.String()).Code)
+	assert.Equal(t, http.StatusNotFound, call(handler.Restore, TypeTag, created.ID.String()).Code)
+
+	restored, err := tag.NewTagModel(db).GetByID(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, false, restored.DeletedAt.Valid)
+	assert.Equal(t, "00000000-0000-0000-0000-000000000000", restored.DeletedByID.String())
+
+	assert.Equal(t, http.StatusOK, call(handler.Restore, TypeUser, member.ID.String()).Code)
+	// Every role comes back, not only the ones the permission bits imply
+	roles, err := permission.NewRoleModel(db).GetUserRoles(member.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, role := range roles {
+		names = append(names, role.RoleName)
+	}
+	assert.Equal(t, []string{permission.RoleJanitor, permission.RoleMember, "trash-curator"}, names)
+
+	// Only items past the retention period are purged by the job
+	if err := tag.NewTagModel(db).Delete(created.ID, admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, NewModel(db).PurgeExpired(time.Now().Add(-cfg.Trash.Retention), log))
+	db.Unscoped().Model(&tag.Tag{}).Where("id = ?", created.ID).Update("deleted_at", time.Now().Add(-2*time.Hour))
+	assert.Equal(t, 1, NewModel(db).PurgeExpired(time.Now().Add(-cfg.Trash.Retention), log))
+
+	count := int64(0)
+	db.Unscoped().Model(&tag.Tag{}).Where("id = ?", created.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	// Purging a user keeps what they created but forgets who did it
+	authored, err := tag.NewTagModel(db).Create(tag.Tag{Slug: "trash-authored", Name: "Authored", CategoryID: category.ID, AuditFields: common.AuditFields{CreatedByID: member.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	invite := account.Invite{Code: "trash-invite", CreatedByID: member.ID}
+	if err := db.Create(&invite).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&oidc.Identity{UserID: member.ID, Issuer: "https://id.example.com", Subject: "trash-member"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&notification.Notification{UserID: member.ID, Kind: notification.KindReportResolved, Message: "-"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := account.NewUserModel(db).Delete(member.ID); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, call(handler.Purge, TypeUser, member.ID.String()).Code)
+
+	db.Unscoped().Model(&account.User{}).Where("id = ?", member.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	db.Model(&tag.Tag{}).Where("id = ? AND created_by_id IS NULL", authored.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+	db.Model(&account.Invite{}).Where("id = ? AND created_by_id IS NULL", invite.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+	for _, owned := range []interface{}{&oidc.Identity{}, &notification.Notification{}, &permission.UserRole{}} {
+		db.Model(owned).Where("user_id = ?", member.ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+	}
+}
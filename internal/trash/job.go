@@ -0,0 +1,59 @@
+package trash
+
+import (
+	"maribooru/internal/audit"
+	"maribooru/internal/config"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// PurgeExpired permanently deletes everything that has been in the trash
+// since before the given time. Tags go first so purged categories aren't
+// blocked by them, items that still fail are logged and retried next run.
+func (m *Model) PurgeExpired(before time.Time, log *zap.Logger) int {
+	purged := 0
+	for _, itemType := range []string{TypeTag, TypeCategory, TypeUser} {
+		ids, err := m.GetExpiredIDs(itemType, before)
+		if err != nil {
+			log.Error("Failed to get expired trash", zap.String("type", itemType), zap.Error(err))
+			continue
+		}
+
+		for _, id := range ids {
+			if err := m.Purge(itemType, id); err != nil {
+				log.Warn("Failed to purge expired trash", zap.String("type", itemType), zap.String("id", id.String()), zap.Error(err))
+				continue
+			}
+			audit.Record(m.db, log, nil, audit.Log{
+				Action:     audit.ActionTrashPurged,
+				TargetType: auditTargets[itemType],
+				TargetID:   id.String(),
+				Detail:     audit.ToJSON(map[string]string{"reason": "retention expired"}),
+			})
+			purged++
+		}
+	}
+	return purged
+}
+
+// RunPurgeJob empties expired trash on every interval until the process
+// exits, a zero retention keeps the trash forever
+func RunPurgeJob(db *gorm.DB, cfg config.Trash, log *zap.Logger) {
+	if cfg.Retention <= 0 || cfg.PurgeInterval <= 0 {
+		log.Info("Trash purge job disabled")
+		return
+	}
+
+	model := NewModel(db)
+	ticker := time.NewTicker(cfg.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if purged := model.PurgeExpired(time.Now().Add(-cfg.Retention), log); purged > 0 {
+			log.Info("Purged expired trash", zap.Int("count", purged))
+		}
+		<-ticker.C
+	}
+}
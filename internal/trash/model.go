@@ -0,0 +1,306 @@
+package trash
+
+import (
+	"errors"
+	"maribooru/internal/account"
+	"maribooru/internal/notification"
+	"maribooru/internal/oidc"
+	"maribooru/internal/permission"
+	"maribooru/internal/report"
+	"maribooru/internal/tag"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type (
+	Item struct {
+		ID          uuid.UUID
+		Type        string
+		Slug        string
+		Name        string
+		DeletedAt   time.Time
+		DeletedByID uuid.UUID
+		DeletedBy   account.User
+	}
+
+	ItemSlice []Item
+
+	Model struct {
+		db *gorm.DB
+	}
+)
+
+const (
+	TypeTag      = "tags"
+	TypeCategory = "categories"
+	TypeUser     = "users"
+)
+
+var (
+	ErrUnknownType = errors.New("Unknown trash type")
+	// ErrParentDeleted is returned when restoring a tag whose category is
+	// still in the trash
+	ErrParentDeleted = errors.New("Parent is deleted")
+)
+
+func NewModel(db *gorm.DB) *Model {
+	return &Model{
+		db: db,
+	}
+}
+
+func (m *Model) trashed(itemType string) (*gorm.DB, error) {
+	tx := m.db.Unscoped()
+	switch itemType {
+	case TypeTag:
+		tx = tx.Model(&tag.Tag{}).Preload("DeletedBy")
+	case TypeCategory:
+		tx = tx.Model(&tag.TagCategory{}).Preload("DeletedBy")
+	case TypeUser:
+		tx = tx.Model(&account.User{})
+	default:
+		return nil, ErrUnknownType
+	}
+	return tx.Where("deleted_at IS NOT NULL"), nil
+}
+
+func (m *Model) GetAll(itemType string, params Params) (ItemSlice, int64, error) {
+	tx, err := m.trashed(itemType)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := int64(0)
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	tx = tx.Order("deleted_at desc").Limit(params.Limit).Offset(params.Offset)
+
+	items := ItemSlice{}
+	switch itemType {
+	case TypeTag:
+		tags := tag.TagSlice{}
+		err = tx.Find(&tags).Error
+		for _, t := range tags {
+			items = append(items, Item{ID: t.ID, Type: itemType, Slug: t.Slug, Name: t.Name, DeletedAt: t.DeletedAt.Time, DeletedByID: t.DeletedByID, DeletedBy: t.DeletedBy})
+		}
+	case TypeCategory:
+		categories := tag.TagCategorySlice{}
+		err = tx.Find(&categories).Error
+		for _, c := range categories {
+			items = append(items, Item{ID: c.ID, Type: itemType, Slug: c.Slug, Name: c.Name, DeletedAt: c.DeletedAt.Time, DeletedByID: c.DeletedByID, DeletedBy: c.DeletedBy})
+		}
+	case TypeUser:
+		users := account.UserSlice{}
+		err = tx.Find(&users).Error
+		for _, u := range users {
+			items = append(items, Item{ID: u.ID, Type: itemType, Name: u.Name, DeletedAt: u.DeletedAt.Time})
+		}
+	}
+
+	return items, total, err
+}
+
+func (m *Model) GetByID(itemType string, id uuid.UUID) (Item, error) {
+	tx, err := m.trashed(itemType)
+	if err != nil {
+		return Item{}, err
+	}
+
+	item := Item{ID: id, Type: itemType}
+	switch itemType {
+	case TypeTag:
+		t := tag.Tag{}
+		err = tx.Where("id = ?", id).First(&t).Error
+		item.Slug, item.Name, item.DeletedAt, item.DeletedByID = t.Slug, t.Name, t.DeletedAt.Time, t.DeletedByID
+	case TypeCategory:
+		c := tag.TagCategory{}
+		err = tx.Where("id = ?", id).First(&c).Error
+		item.Slug, item.Name, item.DeletedAt, item.DeletedByID = c.Slug, c.Name, c.DeletedAt.Time, c.DeletedByID
+	case TypeUser:
+		u := account.User{}
+		err = tx.Where("id = ?", id).First(&u).Error
+		item.Name, item.DeletedAt = u.Name, u.DeletedAt.Time
+	}
+
+	return item, err
+}
+
+// Restore takes the item out of the trash. Restored users get their
+// permission bits and every role they had back, apart from roles deleted in
+// the meantime.
+func (m *Model) Restore(itemType string, id uuid.UUID) error {
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		var res *gorm.DB
+		switch itemType {
+		case TypeTag:
+			t := tag.Tag{}
+			if err := tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).First(&t).Error; err != nil {
+				return err
+			}
+			count := int64(0)
+			if err := tx.Model(&tag.TagCategory{}).Where("id = ?", t.CategoryID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return ErrParentDeleted
+			}
+			res = tx.Unscoped().Model(&tag.Tag{}).
+				Where("id = ?", id).
+				Updates(map[string]interface{}{"deleted_at": nil, "deleted_by_id": nil})
+		case TypeCategory:
+			res = tx.Unscoped().Model(&tag.TagCategory{}).
+				Where("id = ? AND deleted_at IS NOT NULL", id).
+				Updates(map[string]interface{}{"deleted_at": nil, "deleted_by_id": nil})
+		case TypeUser:
+			res = tx.Unscoped().Model(&account.User{}).
+				Where("id = ? AND deleted_at IS NOT NULL", id).
+				Update("deleted_at", nil)
+		default:
+			return ErrUnknownType
+		}
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if itemType != TypeUser {
+			return nil
+		}
+
+		restored, err := permission.NewRoleModel(tx).RestoreUserRoles(id)
+		if err != nil {
+			return err
+		}
+
+		userPermission := permission.Permission{}
+		err = tx.Unscoped().Model(&permission.Permission{}).Where("user_id = ?", id).First(&userPermission).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&permission.Permission{}).Where("user_id = ?", id).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		if restored {
+			return nil
+		}
+		// Users deleted before their roles were kept only get the roles
+		// matching their permission bits
+		return permission.NewRoleModel(tx).SyncLevelRoles(id, userPermission.Permission)
+	})
+}
+
+// Purge permanently deletes an item that is in the trash
+func (m *Model) Purge(itemType string, id uuid.UUID) error {
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		var res *gorm.DB
+		switch itemType {
+		case TypeTag:
+			if err := tag.DeleteArtist(tx, id); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			res = tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).Delete(&tag.Tag{})
+		case TypeCategory:
+			// Tags in the trash still point at the category
+			ids := []uuid.UUID{}
+			if err := tx.Unscoped().Model(&tag.Tag{}).Where("category_id = ? AND deleted_at IS NOT NULL", id).Pluck("id", &ids).Error; err != nil {
+				return err
+			}
+			for _, tagID := range ids {
+				if err := tag.DeleteArtist(tx, tagID); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+					return err
+				}
+			}
+			if err := tx.Unscoped().Where("category_id = ? AND deleted_at IS NOT NULL", id).Delete(&tag.Tag{}).Error; err != nil {
+				return err
+			}
+			res = tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).Delete(&tag.TagCategory{})
+		case TypeUser:
+			if err := detachUser(tx, id); err != nil {
+				return err
+			}
+			res = tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).Delete(&account.User{})
+		default:
+			return ErrUnknownType
+		}
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// userReferences are the columns recording who did something, they're
+// cleared when the user is purged so the records themselves survive
+var userReferences = []struct {
+	model   interface{}
+	columns []string
+}{
+	{&tag.Tag{}, []string{"created_by_id", "updated_by_id", "deleted_by_id"}},
+	{&tag.TagCategory{}, []string{"created_by_id", "updated_by_id", "deleted_by_id"}},
+	{&tag.Artist{}, []string{"updated_by_id"}},
+	{&account.Invite{}, []string{"created_by_id"}},
+	{&account.Ban{}, []string{"moderator_id", "lifted_by_id"}},
+	{&report.Report{}, []string{"reporter_id", "claimed_by_id", "closed_by_id"}},
+}
+
+// detachUser removes everything owned by the user and clears every
+// reference to them, so the user row can be deleted without tripping
+// foreign keys
+func detachUser(tx *gorm.DB, id uuid.UUID) error {
+	for _, owned := range []interface{}{
+		&permission.Permission{},
+		&account.Admin{},
+		&permission.UserRole{},
+		&permission.TrashedUserRole{},
+		&account.Ban{},
+		&oidc.Identity{},
+		&notification.Notification{},
+	} {
+		if err := tx.Unscoped().Where("user_id = ?", id).Delete(owned).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Where("link_user_id = ?", id).Delete(&oidc.AuthRequest{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("key = ?", "user:"+id.String()).Delete(&account.LoginAttempt{}).Error; err != nil {
+		return err
+	}
+
+	for _, reference := range userReferences {
+		for _, column := range reference.columns {
+			err := tx.Unscoped().
+				Model(reference.model).
+				Where(column+" = ?", id).
+				UpdateColumn(column, nil).
+				Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetExpiredIDs lists the items of a type deleted before the given time
+func (m *Model) GetExpiredIDs(itemType string, before time.Time) ([]uuid.UUID, error) {
+	tx, err := m.trashed(itemType)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []uuid.UUID{}
+	err = tx.Where("deleted_at < ?", before).Pluck("id", &ids).Error
+	return ids, err
+}